@@ -2,25 +2,178 @@ package cmd
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/cbartram/hearthhub-common/model"
 	log "github.com/sirupsen/logrus"
 	"io"
 	"net/http"
+	"path/filepath"
 	"strings"
+	"time"
+)
+
+const (
+	defaultPollIntervalSeconds = 2
+	defaultStopTimeoutSeconds  = 120
 )
 
 type HearthHubClient struct {
-	BaseUrl string
+	BaseUrl      string
+	Retry        *RetryPolicy
+	PollInterval time.Duration
+	StopTimeout  time.Duration
+	LockFiles    []string // Glob patterns for files on the PVC which only exist while the server is running
+}
+
+// ServerStopTimeoutError is returned when the Valheim server is still running (or still holds its lock files) after
+// the timeout given to WaitForServerStop.
+type ServerStopTimeoutError struct {
+	Timeout time.Duration
+	Reason  string
+}
+
+func (e *ServerStopTimeoutError) Error() string {
+	return fmt.Sprintf("valheim server did not stop within %v: %s", e.Timeout, e.Reason)
+}
+
+type serverStatusResponse struct {
+	State string `json:"state"`
 }
 
-func MakeHearthHubClient(baseUrl string) *HearthHubClient {
+// MakeHearthHubClient Creates a new HearthHub API client. How long to wait for the server to stop and which lock files
+// to watch for are read from the SERVER_STOP_TIMEOUT_SECONDS and SERVER_LOCK_FILES (comma separated globs) environment
+// variables.
+func MakeHearthHubClient(baseUrl string, retry *RetryPolicy) *HearthHubClient {
 	return &HearthHubClient{
-		BaseUrl: baseUrl,
+		BaseUrl:      baseUrl,
+		Retry:        retry,
+		PollInterval: defaultPollIntervalSeconds * time.Second,
+		StopTimeout:  time.Duration(getEnvInt64("SERVER_STOP_TIMEOUT_SECONDS", defaultStopTimeoutSeconds)) * time.Second,
+		LockFiles:    getEnvList("SERVER_LOCK_FILES"),
+	}
+}
+
+// WaitForServerStop Polls the server status endpoint until the Valheim server reports it has terminated and none of
+// the client's lock file patterns (i.e. a pid file or world .db lock on the PVC) match a file. Returns a
+// ServerStopTimeoutError if the server hasn't fully stopped within the client's StopTimeout so world files are never
+// touched while the server may still be writing them.
+func (h *HearthHubClient) WaitForServerStop(fileManager *FileManager) error {
+	timeout := h.StopTimeout
+	deadline := time.Now().Add(timeout)
+	interval := h.PollInterval
+	if interval <= 0 {
+		interval = defaultPollIntervalSeconds * time.Second
+	}
+
+	// Requests share the deadline so a status request which hangs can't hold the wait past the timeout.
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	for {
+		reason := ""
+		state, err := h.serverState(ctx, fileManager)
+		if errors.Is(err, context.DeadlineExceeded) {
+			return &ServerStopTimeoutError{Timeout: timeout, Reason: fmt.Sprintf("failed to get server status: %v", err)}
+		} else if err != nil {
+			reason = fmt.Sprintf("failed to get server status: %v", err)
+		} else if state != model.TERMINATED {
+			reason = fmt.Sprintf("server state is: %s", state)
+		} else if lockFile := findLockFile(h.LockFiles); lockFile != "" {
+			reason = fmt.Sprintf("lock file %s still exists", lockFile)
+		}
+
+		if reason == "" {
+			log.Infof("valheim server has stopped")
+			return nil
+		}
+
+		if time.Now().Add(interval).After(deadline) {
+			return &ServerStopTimeoutError{Timeout: timeout, Reason: reason}
+		}
+
+		log.Infof("waiting for valheim server to stop: %s", reason)
+		time.Sleep(interval)
+	}
+}
+
+// serverState Fetches the current state of the user's Valheim server i.e. "running" or "terminated". A user without a
+// server has nothing running so a 404 is reported as "terminated".
+func (h *HearthHubClient) serverState(ctx context.Context, fileManager *FileManager) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/api/v1/server/status", h.BaseUrl), nil)
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(fileManager.DiscordId, fileManager.RefreshToken)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if res.StatusCode == http.StatusNotFound {
+		return model.TERMINATED, nil
+	}
+
+	if res.StatusCode != 200 {
+		return "", fmt.Errorf("status code: %v, body: %s", res.StatusCode, string(body))
+	}
+
+	var status serverStatusResponse
+	if err := json.Unmarshal(body, &status); err != nil {
+		return "", fmt.Errorf("failed to parse server status: %v", err)
+	}
+	return status.State, nil
+}
+
+// findLockFile Returns the first file matching any of the given glob patterns or an empty string when none match.
+func findLockFile(patterns []string) string {
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			log.Warnf("invalid lock file pattern %s: %v", pattern, err)
+			continue
+		}
+		if len(matches) > 0 {
+			return matches[0]
+		}
 	}
+	return ""
 }
 
-// ScaleDeployment Scales a Kubernetes Valheim Dedicated Server Deployment to either 1 or 0.
+// ScaleDeployment Scales a Kubernetes Valheim Dedicated Server Deployment to either 1 or 0. Network failures and 5xx
+// responses are retried according to the client's retry policy.
 func (h *HearthHubClient) ScaleDeployment(fileManager *FileManager, scale int) error {
+	_, err := h.scale(fileManager, scale)
+	return err
+}
+
+// StopServer Scales the user's Valheim server down to 0 returning false when there was no server running to stop, in
+// which case there's nothing to wait for.
+func (h *HearthHubClient) StopServer(fileManager *FileManager) (bool, error) {
+	return h.scale(fileManager, 0)
+}
+
+// scale Scales the deployment with retries returning false when it was already at the given scale.
+func (h *HearthHubClient) scale(fileManager *FileManager, scale int) (bool, error) {
+	scaled := true
+	err := h.Retry.Do(context.Background(), fmt.Sprintf("scale deployment to %v", scale), func() error {
+		var err error
+		scaled, err = h.scaleDeployment(fileManager, scale)
+		return err
+	})
+	return scaled, err
+}
+
+func (h *HearthHubClient) scaleDeployment(fileManager *FileManager, scale int) (bool, error) {
 	method := "PUT"
 	url := fmt.Sprintf("%s/api/v1/server/scale", h.BaseUrl)
 
@@ -28,20 +181,20 @@ func (h *HearthHubClient) ScaleDeployment(fileManager *FileManager, scale int) e
 	req, err := http.NewRequest(method, url, bytes.NewReader([]byte(fmt.Sprintf(`{"replicas": %v}`, scale))))
 
 	if err != nil {
-		return err
+		return false, err
 	}
 	req.SetBasicAuth(fileManager.DiscordId, fileManager.RefreshToken)
 
 	res, err := client.Do(req)
 
 	if err != nil {
-		return err
+		return false, err
 	}
 	defer res.Body.Close()
 
 	body, err := io.ReadAll(res.Body)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	bodyString := string(body)
@@ -50,13 +203,18 @@ func (h *HearthHubClient) ScaleDeployment(fileManager *FileManager, scale int) e
 	if res.StatusCode != 200 {
 		// If server is already scaled to 0 we get a 400 status code, but it's already in the state we want.
 		if scale == 0 && res.StatusCode == 400 && strings.Contains(bodyString, "no server to terminate") {
-			return nil
+			return false, nil
 		}
 
 		if scale == 1 && res.StatusCode == 400 && strings.Contains(bodyString, "server already running") {
-			return nil
+			return false, nil
+		}
+
+		err = fmt.Errorf("failed to scale replica to: %v, status code: %v, body: %s", scale, res.StatusCode, bodyString)
+		if isRetryableStatus(res.StatusCode) {
+			return false, &RetryableError{Err: err}
 		}
-		return fmt.Errorf("failed to scale replica to: %v, status code: %v, body: %s", scale, res.StatusCode, bodyString)
+		return false, err
 	}
-	return nil
+	return true, nil
 }
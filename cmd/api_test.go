@@ -1,16 +1,24 @@
 package cmd
 
 import (
+	"fmt"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestMakeHearthHubClient(t *testing.T) {
-	c := MakeHearthHubClient("foo")
+	t.Setenv("SERVER_LOCK_FILES", "/valheim/server.pid, ,/valheim/*.lock")
+	c := MakeHearthHubClient("foo", nil)
 	assert.NotNil(t, c)
 	assert.Equal(t, "foo", c.BaseUrl)
+	assert.Equal(t, defaultStopTimeoutSeconds*time.Second, c.StopTimeout)
+	assert.Equal(t, []string{"/valheim/server.pid", "/valheim/*.lock"}, c.LockFiles)
 }
 
 func TestScaleDeployment(t *testing.T) {
@@ -101,3 +109,158 @@ func TestScaleDeployment(t *testing.T) {
 		})
 	}
 }
+
+func TestScaleDeployment_RetriesTransientErrors(t *testing.T) {
+	tests := []struct {
+		name         string
+		statusCodes  []int
+		wantAttempts int
+		wantErr      bool
+	}{
+		{name: "recovers after 503", statusCodes: []int{503, 502, 200}, wantAttempts: 3, wantErr: false},
+		{name: "gives up after max attempts", statusCodes: []int{503, 503, 503, 503}, wantAttempts: 3, wantErr: true},
+		{name: "does not retry 401", statusCodes: []int{401, 200}, wantAttempts: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.statusCodes[attempts])
+				attempts++
+			}))
+			defer server.Close()
+
+			client := MakeHearthHubClient(server.URL, makeTestRetryPolicy(3, nil))
+			err := client.ScaleDeployment(&FileManager{DiscordId: "id", RefreshToken: "token"}, 0)
+
+			assert.Equal(t, tt.wantErr, err != nil)
+			assert.Equal(t, tt.wantAttempts, attempts)
+		})
+	}
+}
+
+func TestWaitForServerStop(t *testing.T) {
+	tests := []struct {
+		name       string
+		states     []string
+		statusCode int
+		lockFile   bool
+		wantErr    bool
+	}{
+		{name: "already terminated", states: []string{"terminated"}, statusCode: 200},
+		{name: "terminates after polling", states: []string{"running", "running", "terminated"}, statusCode: 200},
+		{name: "never terminates", states: []string{"running"}, statusCode: 200, wantErr: true},
+		{name: "status endpoint failing", states: []string{"terminated"}, statusCode: 500, wantErr: true},
+		{name: "lock file never released", states: []string{"terminated"}, statusCode: 200, lockFile: true, wantErr: true},
+		{name: "no server", states: []string{"running"}, statusCode: 404},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			polls := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "/api/v1/server/status", r.URL.Path)
+				state := tt.states[min(polls, len(tt.states)-1)]
+				polls++
+				w.WriteHeader(tt.statusCode)
+				w.Write([]byte(fmt.Sprintf(`{"state": "%s"}`, state)))
+			}))
+			defer server.Close()
+
+			dir := t.TempDir()
+			if tt.lockFile {
+				require.NoError(t, os.WriteFile(filepath.Join(dir, "valheim.pid"), []byte("1"), 0644))
+			}
+
+			client := &HearthHubClient{
+				BaseUrl:      server.URL,
+				PollInterval: 5 * time.Millisecond,
+				StopTimeout:  100 * time.Millisecond,
+				LockFiles:    []string{filepath.Join(dir, "*.pid")},
+			}
+
+			err := client.WaitForServerStop(&FileManager{DiscordId: "id", RefreshToken: "token"})
+			if tt.wantErr {
+				var timeoutErr *ServerStopTimeoutError
+				require.ErrorAs(t, err, &timeoutErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, len(tt.states), polls)
+		})
+	}
+}
+
+func TestWaitForServerStop_StatusHangs(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client := &HearthHubClient{
+		BaseUrl:      server.URL,
+		PollInterval: 5 * time.Millisecond,
+		StopTimeout:  50 * time.Millisecond,
+	}
+
+	start := time.Now()
+	err := client.WaitForServerStop(&FileManager{DiscordId: "id", RefreshToken: "token"})
+	var timeoutErr *ServerStopTimeoutError
+	require.ErrorAs(t, err, &timeoutErr)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestStopServer(t *testing.T) {
+	tests := []struct {
+		name        string
+		statusCode  int
+		body        string
+		wantRunning bool
+	}{
+		{name: "scaled down", statusCode: 200, body: `{"status": "success"}`, wantRunning: true},
+		{name: "no server", statusCode: 400, body: `{"error": "no server to terminate"}`, wantRunning: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.statusCode)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			running, err := (&HearthHubClient{BaseUrl: server.URL}).StopServer(&FileManager{DiscordId: "id", RefreshToken: "token"})
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantRunning, running)
+		})
+	}
+}
+
+func TestWaitForServerStop_LockFileReleased(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"state": "terminated"}`))
+	}))
+	defer server.Close()
+
+	lockFile := filepath.Join(t.TempDir(), "world.db.lock")
+	require.NoError(t, os.WriteFile(lockFile, []byte{}, 0644))
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		os.Remove(lockFile)
+	}()
+
+	client := &HearthHubClient{
+		BaseUrl:      server.URL,
+		PollInterval: 5 * time.Millisecond,
+		StopTimeout:  time.Second,
+		LockFiles:    []string{lockFile},
+	}
+
+	err := client.WaitForServerStop(&FileManager{DiscordId: "id", RefreshToken: "token"})
+	require.NoError(t, err)
+	_, err = os.Stat(lockFile)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -1,89 +1,439 @@
 package cmd
 
 import (
-	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	log "github.com/sirupsen/logrus"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 )
 
 type Archive struct {
 	ZipFilePath string
 	Destination string
+	Layout      string            // How entries are mapped to the destination, either LayoutFlat or LayoutThunderstore
+	Force       bool              // Install even when files collide with other installed mods
+	Limits      *ExtractionLimits // Nil uses the limits configured by MakeExtractionLimits
 }
 
-// RemoveFilesFromZip Removes all the files that are present in a zip file from the destination as well as the zip file itself.
-// This function reads the zip file to determine which files to delete and is used for mod uninstallation.
-func (a *Archive) RemoveFilesFromZip() error {
-	zipReader, err := zip.OpenReader(a.ZipFilePath)
+// UnsafePathError is returned when an entry in an archive would resolve to a location outside the archive's
+// destination directory i.e. a "zip slip" entry like ../../root/.config/foo, an absolute path, or a symlink.
+type UnsafePathError struct {
+	Entry  string
+	Reason string
+}
+
+func (e *UnsafePathError) Error() string {
+	return fmt.Sprintf("unsafe archive entry %q: %s", e.Entry, e.Reason)
+}
+
+// resolveEntryPath Resolves the name of an archive entry to a path within the destination directory. Windows style
+// separators are normalized before resolving so that entries like ..\..\foo are caught as well. An UnsafePathError
+// is returned for any entry which is absolute or whose resolved path leaves the destination.
+func resolveEntryPath(destination, name string) (string, error) {
+	normalized := strings.ReplaceAll(name, `\`, "/")
+	if strings.HasPrefix(normalized, "/") || filepath.IsAbs(name) {
+		return "", &UnsafePathError{Entry: name, Reason: "absolute path"}
+	}
+
+	// Drive letters such as C:/ are absolute on Windows but not on Linux so check for them explicitly.
+	if len(normalized) >= 2 && normalized[1] == ':' {
+		return "", &UnsafePathError{Entry: name, Reason: "absolute path"}
+	}
+
+	cleanDestination := filepath.Clean(destination)
+	path := filepath.Join(cleanDestination, filepath.FromSlash(normalized))
+	rel, err := filepath.Rel(cleanDestination, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", &UnsafePathError{Entry: name, Reason: "path escapes destination"}
+	}
+
+	return path, nil
+}
+
+// resolveEntries Resolves every entry in the archive to its path on disk before anything is written or removed so
+// that a single hostile entry rejects the whole archive rather than leaving it partially applied. Entries which
+// shouldn't be installed, like the install root itself, resolve to an empty path.
+func (a *Archive) resolveEntries(entries []archiveEntry) ([]string, error) {
+	for _, entry := range entries {
+		if entry.Mode&os.ModeSymlink != 0 {
+			return nil, &UnsafePathError{Entry: entry.Name, Reason: "symbolic links are not allowed"}
+		}
+
+		if !entry.IsDir() && !entry.Mode.IsRegular() {
+			return nil, &UnsafePathError{Entry: entry.Name, Reason: "only regular files and directories are allowed"}
+		}
+	}
+
+	var paths []string
+	if a.Layout == LayoutThunderstore {
+		var err error
+		if paths, err = a.resolveThunderstoreEntries(entries); err != nil {
+			return nil, err
+		}
+	} else {
+		paths = make([]string, len(entries))
+		for i, entry := range entries {
+			path, err := resolveEntryPath(a.Destination, entry.Name)
+			if err != nil {
+				return nil, err
+			}
+			paths[i] = path
+		}
+	}
+
+	root := a.installRoot()
+	reserved := []string{filepath.Join(filepath.Clean(a.Destination), workDirName), stagingRoot(a.Destination)}
+	for i, path := range paths {
+		for _, dir := range reserved {
+			if path == dir || strings.HasPrefix(path, dir+string(filepath.Separator)) {
+				return nil, &UnsafePathError{Entry: entries[i].Name, Reason: "path is reserved for the file manager"}
+			}
+		}
+
+		if path == root {
+			paths[i] = ""
+		}
+	}
+	return paths, nil
+}
+
+// RemoveFilesFromZip Removes all the files that an archive installed from the destination as well as the archive itself.
+// The install manifest written by UnzipFile is used to determine which files to delete so the right files are removed
+// even if the archive has since been replaced or deleted. Archives installed before manifests existed fall back to
+// reading the archive. Used for mod uninstallation. Every format supported by openArchive can be removed.
+func (a *Archive) RemoveFilesFromZip() (*UninstallReport, error) {
+	path := manifestPath(a.Destination, a.ZipFilePath)
+	manifest, err := readManifest(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	var report *UninstallReport
+	switch {
+	case manifest == nil:
+		log.Infof("no install manifest found for %s, using the files in the archive", a.ZipFilePath)
+		report, err = a.removeArchiveEntries()
+	case manifest.Disabled:
+		log.Infof("removing disabled files of %s from %s", a.ZipFilePath, a.disabledDir())
+		report = &UninstallReport{}
+		for _, file := range manifest.Files {
+			report.Removed = append(report.Removed, file.Path)
+		}
+		removeDisabledDir(a.disabledDir())
+	default:
+		log.Infof("removing files installed by %s using manifest: %s", a.ZipFilePath, path)
+		report, err = uninstallManifest(manifest)
+	}
 	if err != nil {
-		log.Errorf("failed to open ZIP file: %v", err)
-		return err
+		return report, err
+	}
+
+	if manifest != nil {
+		if err := os.Remove(path); err != nil {
+			return report, err
+		}
+		os.Remove(filepath.Dir(path))
+		os.Remove(filepath.Join(a.Destination, workDirName))
 	}
-	defer zipReader.Close()
 
-	// Iterate over the files in the ZIP and remove them from the PVC
-	for _, f := range zipReader.File {
-		filePath := filepath.Join(a.Destination, f.Name)
+	if err := os.Remove(a.ZipFilePath); err != nil && (manifest == nil || !os.IsNotExist(err)) {
+		return report, fmt.Errorf("failed to remove archive file: %w", err)
+	}
+	return report, nil
+}
+
+// removeArchiveEntries Removes every file in the destination which has the same name as a file in the archive.
+func (a *Archive) removeArchiveEntries() (*UninstallReport, error) {
+	reader, err := a.open()
+	if err != nil {
+		log.Errorf("failed to open archive: %v", err)
+		return nil, err
+	}
+	defer reader.Close()
+
+	entries := reader.Entries()
+	paths, err := a.resolveEntries(entries)
+	if err != nil {
+		log.Errorf("refusing to remove files for %s: %v", a.ZipFilePath, err)
+		return nil, err
+	}
+
+	// Iterate over the files in the archive and remove them from the PVC
+	report := &UninstallReport{}
+	for i, filePath := range paths {
+		if filePath == "" {
+			continue
+		}
+
 		log.Infof("removing file %s", filePath)
 		if err := os.Remove(filePath); err != nil {
 			if os.IsNotExist(err) {
 				log.Infof("file %s does not exist, skipping...", filePath)
+				report.Missing = append(report.Missing, entries[i].Name)
 				continue
 			}
 			log.Errorf("Failed to remove file %s: %v", filePath, err)
+			continue
 		}
+		report.Removed = append(report.Removed, entries[i].Name)
 	}
-
-	if err := os.Remove(a.ZipFilePath); err != nil {
-		log.Fatalf("failed to remove temporary ZIP file: %v", err)
-	}
-	return nil
+	return report, nil
 }
 
-// UnzipFile Unpacks an archived zip file to the specified path in the Archive class. Note:
+// UnzipFile Unpacks an archive to the specified path in the Archive class. Despite the name zip, tar.gz, tar.zst and 7z
+// archives are all supported with the format detected from the file's magic bytes. Note:
 // it is very important that the zip file is NOT cleaned up after unpack. Zip file names are used by the frontend
 // as the source of truth for a mod. If the .dll files in the zip for the mod name don't match the zip file name
 // then there are problems identifying which mods are actually installed. Therefore, leave the zip file alone after it's
 // been downloaded!! Future downloads will just overwrite it so no big deal.
+//
+// The archive is first extracted into a staging directory on the same volume and validated there. Only then are the
+// files swapped into place. If anything fails the destination is restored to its pre-install state so an install
+// either fully applies or leaves the PVC untouched.
+//
+// Before anything is extracted the archive's files are checked against the manifests of every other installed archive.
+// An InstallConflictError is returned if they would overwrite another mod's files or another version of the same mod
+// is installed unless Force is set.
+//
+// Extraction stops with a LimitExceededError as soon as the archive crosses one of its ExtractionLimits.
 func (a *Archive) UnzipFile() error {
-	reader, err := zip.OpenReader(a.ZipFilePath)
+	meter, err := a.meter()
+	if err != nil {
+		return err
+	}
+
+	reader, err := openArchive(a.ZipFilePath, meter)
 	if err != nil {
 		return err
 	}
 	defer reader.Close()
 
-	for _, file := range reader.File {
-		path := filepath.Join(a.Destination, file.Name)
+	entries := reader.Entries()
+	paths, err := a.resolveEntries(entries)
+	if err != nil {
+		return err
+	}
 
-		if file.FileInfo().IsDir() {
-			os.MkdirAll(path, 0755)
-			continue
+	if err := meter.checkEntries(entries); err != nil {
+		return err
+	}
+
+	metadata, err := a.ReadPackageMetadata()
+	if err != nil {
+		log.Warnf("failed to read package metadata from %s: %v", a.ZipFilePath, err)
+	}
+
+	conflict, err := a.findConflicts(entries, paths, metadata)
+	if err != nil {
+		return err
+	}
+	if conflict != nil {
+		if !a.Force {
+			return conflict
 		}
+		log.Warnf("%v, installing anyway since force is set", conflict)
+	}
 
-		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
-			return err
+	// Installs used to stage inside the work dir so anything a killed Job left there is cleaned up too
+	removeStaleStagingDirs(filepath.Join(a.Destination, workDirName))
+	workDir := stagingRoot(a.Destination)
+	removeStaleStagingDirs(workDir)
+	if err := os.MkdirAll(workDir, 0755); err != nil {
+		return err
+	}
+
+	// Removes the staging root once the staging dir is cleaned up as long as nothing else is using it
+	defer os.Remove(workDir)
+
+	stagingDir, err := os.MkdirTemp(workDir, "staging-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(stagingDir)
+
+	manifest := &InstallManifest{
+		Archive:     filepath.Base(a.ZipFilePath),
+		Root:        a.installRoot(),
+		InstalledAt: time.Now(),
+	}
+	if metadata != nil {
+		manifest.Package = metadata.Name
+		manifest.Version = metadata.VersionNumber
+	}
+
+	stagedPaths := make([]string, len(paths))
+	i := 0
+	err = reader.Walk(func(entry archiveEntry, r io.Reader) error {
+		path := paths[i]
+		i++
+		if path == "" {
+			return nil
 		}
 
-		outFile, err := os.Create(path)
+		rel, err := filepath.Rel(manifest.Root, path)
 		if err != nil {
 			return err
 		}
+		staged := filepath.Join(stagingDir, rel)
+		stagedPaths[i-1] = staged
 
-		rc, err := file.Open()
+		if r != nil {
+			r = meter.reader(entry, r)
+		}
+
+		size, sum, err := stageEntry(entry, r, staged)
 		if err != nil {
-			outFile.Close()
-			return err
+			return fmt.Errorf("failed to extract %s: %w", entry.Name, err)
+		}
+
+		if !entry.IsDir() {
+			manifest.Files = append(manifest.Files, ManifestFile{Path: filepath.ToSlash(rel), Size: size, SHA256: sum})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	log.Infof("staged %d entries from %s", len(entries), a.ZipFilePath)
+
+	tx, err := beginInstall(workDir)
+	if err != nil {
+		return err
+	}
+
+	for i, entry := range entries {
+		if paths[i] == "" {
+			continue
+		}
+
+		if entry.IsDir() {
+			err = tx.mkdirAll(paths[i])
+		} else {
+			err = tx.install(stagedPaths[i], paths[i])
 		}
 
-		_, err = io.Copy(outFile, rc)
-		outFile.Close()
-		rc.Close()
 		if err != nil {
-			return err
+			return rollbackInstall(tx, fmt.Errorf("failed to install %s: %w", paths[i], err))
+		}
+	}
+
+	path := manifestPath(a.Destination, a.ZipFilePath)
+	previous, _ := readManifest(path)
+	if err := dropPreviousFiles(tx, manifest, previous); err != nil {
+		return rollbackInstall(tx, fmt.Errorf("failed to remove files from the previous install: %w", err))
+	}
+
+	for _, dir := range tx.createdDirs {
+		rel, err := filepath.Rel(manifest.Root, dir)
+		if err != nil {
+			return rollbackInstall(tx, err)
 		}
+		manifest.Dirs = append(manifest.Dirs, filepath.ToSlash(rel))
+	}
+	manifest.Dirs = mergeDirs(manifest.Root, manifest.Dirs, previous)
+
+	if err := writeManifest(path, manifest); err != nil {
+		return rollbackInstall(tx, fmt.Errorf("failed to write install manifest: %w", err))
+	}
+
+	if conflict != nil {
+		a.releaseCollisions(conflict)
 	}
 
-	return nil
+	// Reinstalling a disabled mod replaces the copy that was disabled
+	if previous != nil && previous.Disabled {
+		removeDisabledDir(a.disabledDir())
+	}
+
+	return tx.commit()
+}
+
+// meter Returns a meter enforcing the archive's extraction limits.
+func (a *Archive) meter() (*extractionMeter, error) {
+	info, err := os.Stat(a.ZipFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	limits := a.Limits
+	if limits == nil {
+		limits = MakeExtractionLimits()
+	}
+	return limits.meter(a.ZipFilePath, info.Size()), nil
+}
+
+// open Opens the archive enforcing its extraction limits while it's listed.
+func (a *Archive) open() (archiveReader, error) {
+	meter, err := a.meter()
+	if err != nil {
+		return nil, err
+	}
+	return openArchive(a.ZipFilePath, meter)
+}
+
+// rollbackInstall Rolls back the transaction after the install failed with err.
+func rollbackInstall(tx *installTransaction, err error) error {
+	log.Errorf("%v, rolling back", err)
+	if rollbackErr := tx.rollback(); rollbackErr != nil {
+		return fmt.Errorf("%w (rollback failed: %v)", err, rollbackErr)
+	}
+	return err
+}
+
+// stageEntry Extracts a single archive entry read from r to path and validates that the number of bytes written matches
+// the size recorded in the archive. The entry's permissions, masked by safeFileMode, and modification time are carried
+// over to the extracted file. Returns the size and hex encoded SHA-256 of the extracted file.
+func stageEntry(entry archiveEntry, r io.Reader, path string) (int64, string, error) {
+	if entry.IsDir() {
+		return 0, "", os.MkdirAll(path, 0755)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return 0, "", err
+	}
+
+	outFile, err := os.Create(path)
+	if err != nil {
+		return 0, "", err
+	}
+
+	hash := sha256.New()
+	written, err := io.Copy(io.MultiWriter(outFile, hash), r)
+	outFile.Close()
+	if err != nil {
+		return 0, "", err
+	}
+
+	if written != entry.Size {
+		return 0, "", fmt.Errorf("expected %d bytes but extracted %d", entry.Size, written)
+	}
+
+	// The mode is set after the file is created so the process umask doesn't strip the executable bit
+	if err := os.Chmod(path, safeFileMode(entry.Mode)); err != nil {
+		return 0, "", err
+	}
+
+	if !entry.ModTime.IsZero() {
+		if err := os.Chtimes(path, time.Time{}, entry.ModTime); err != nil {
+			return 0, "", err
+		}
+	}
+	return written, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// safeFileMode Masks the permissions recorded for an archive entry so an archive can't install files which are
+// writable by other users or setuid. The owner can always read and write the file so later installs can replace it.
+// Entries which don't record any permissions get 0644.
+func safeFileMode(mode fs.FileMode) fs.FileMode {
+	if mode.Perm() == 0 {
+		return 0644
+	}
+	return mode.Perm()&0755 | 0600
 }
@@ -0,0 +1,297 @@
+package cmd
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/bodgit/sevenzip"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// ArchiveFormat The container and compression an archive is packed with.
+type ArchiveFormat string
+
+const (
+	FormatZip     ArchiveFormat = "zip"
+	FormatTarGzip ArchiveFormat = "tar.gz"
+	FormatTarZstd ArchiveFormat = "tar.zst"
+	Format7z      ArchiveFormat = "7z"
+)
+
+// archiveSignatures The magic bytes each format starts with. Mods are uploaded with all kinds of (and sometimes
+// wrong) extensions so the contents of the file are trusted over its name.
+var archiveSignatures = []struct {
+	format ArchiveFormat
+	magic  []byte
+}{
+	{format: FormatZip, magic: []byte("PK\x03\x04")},
+	{format: FormatZip, magic: []byte("PK\x05\x06")}, // Empty zip
+	{format: FormatTarGzip, magic: []byte{0x1f, 0x8b}},
+	{format: FormatTarZstd, magic: []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{format: Format7z, magic: []byte{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c}},
+}
+
+// UnsupportedFormatError is returned when a file doesn't start with the magic bytes of any supported archive format.
+type UnsupportedFormatError struct {
+	Path string
+}
+
+func (e *UnsupportedFormatError) Error() string {
+	return fmt.Sprintf("%s is not a zip, tar.gz, tar.zst or 7z archive", e.Path)
+}
+
+// DetectArchiveFormat Determines the format of the archive at path from its magic bytes.
+func DetectArchiveFormat(path string) (ArchiveFormat, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	header := make([]byte, 8)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+
+	for _, signature := range archiveSignatures {
+		if bytes.HasPrefix(header[:n], signature.magic) {
+			return signature.format, nil
+		}
+	}
+	return "", &UnsupportedFormatError{Path: path}
+}
+
+// archiveEntry A file or directory in an archive independent of the archive's format.
+type archiveEntry struct {
+	Name string
+	Mode fs.FileMode
+	Size int64 // Uncompressed size of the entry's contents
+
+	ModTime time.Time // Zero when the archive doesn't record one
+}
+
+func (e archiveEntry) IsDir() bool {
+	return e.Mode.IsDir()
+}
+
+// archiveReader Reads the entries of an archive. Entries lists every entry up front so an archive can be validated
+// before anything is written, and Walk then streams their contents in the same order. Tar archives can only be read
+// sequentially so contents are never accessed out of order.
+type archiveReader interface {
+	Entries() []archiveEntry
+
+	// Walk Calls fn for every entry in order with a reader over its contents. The reader is nil for directories.
+	Walk(fn func(entry archiveEntry, r io.Reader) error) error
+
+	Close() error
+}
+
+// openArchive Opens the archive at path using the reader for its format. Listing a tarball decompresses it so the
+// meter's limits are enforced while it's listed.
+func openArchive(path string, meter *extractionMeter) (archiveReader, error) {
+	format, err := DetectArchiveFormat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case FormatZip:
+		return openZipArchive(path)
+	case FormatTarGzip, FormatTarZstd:
+		return openTarArchive(path, format, meter)
+	case Format7z:
+		return open7zArchive(path)
+	}
+	return nil, &UnsupportedFormatError{Path: path}
+}
+
+type zipArchive struct {
+	reader  *zip.ReadCloser
+	entries []archiveEntry
+}
+
+func openZipArchive(path string) (*zipArchive, error) {
+	reader, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &zipArchive{reader: reader}
+	for _, file := range reader.File {
+		a.entries = append(a.entries, archiveEntry{Name: file.Name, Mode: file.Mode(), Size: int64(file.UncompressedSize64), ModTime: file.Modified})
+	}
+	return a, nil
+}
+
+func (a *zipArchive) Entries() []archiveEntry {
+	return a.entries
+}
+
+// Walk The zip reader verifies each entry's CRC-32 once its contents have been fully read.
+func (a *zipArchive) Walk(fn func(entry archiveEntry, r io.Reader) error) error {
+	for i, file := range a.reader.File {
+		if err := walkEntry(a.entries[i], file.Open, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *zipArchive) Close() error {
+	return a.reader.Close()
+}
+
+type sevenZipArchive struct {
+	reader  *sevenzip.ReadCloser
+	entries []archiveEntry
+}
+
+func open7zArchive(path string) (*sevenZipArchive, error) {
+	reader, err := sevenzip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &sevenZipArchive{reader: reader}
+	for _, file := range reader.File {
+		a.entries = append(a.entries, archiveEntry{Name: file.Name, Mode: file.Mode(), Size: int64(file.UncompressedSize), ModTime: file.Modified})
+	}
+	return a, nil
+}
+
+func (a *sevenZipArchive) Entries() []archiveEntry {
+	return a.entries
+}
+
+func (a *sevenZipArchive) Walk(fn func(entry archiveEntry, r io.Reader) error) error {
+	for i, file := range a.reader.File {
+		if err := walkEntry(a.entries[i], file.Open, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *sevenZipArchive) Close() error {
+	return a.reader.Close()
+}
+
+// walkEntry Opens a single entry of a random access archive and passes it to fn.
+func walkEntry(entry archiveEntry, open func() (io.ReadCloser, error), fn func(entry archiveEntry, r io.Reader) error) error {
+	if entry.IsDir() {
+		return fn(entry, nil)
+	}
+
+	rc, err := open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return fn(entry, rc)
+}
+
+// tarArchive A compressed tarball. Tarballs have no central directory so the entries are listed by reading through
+// the whole archive once when it's opened and then again each time it's walked.
+type tarArchive struct {
+	path    string
+	format  ArchiveFormat
+	entries []archiveEntry
+}
+
+// openTarArchive Lists the entries of the tarball stopping at the first one which crosses one of the meter's limits so
+// a hostile archive is never fully decompressed or held in memory. Nothing is enforced when the meter is nil.
+func openTarArchive(path string, format ArchiveFormat, meter *extractionMeter) (*tarArchive, error) {
+	a := &tarArchive{path: path, format: format}
+	var total int64
+	err := a.read(func(header *tar.Header, _ *tar.Reader) error {
+		entry := archiveEntry{Name: header.Name, Mode: header.FileInfo().Mode(), Size: header.Size, ModTime: header.ModTime}
+		if meter != nil {
+			total += entry.Size
+			if err := meter.checkListed(len(a.entries)+1, entry, total); err != nil {
+				return err
+			}
+		}
+		a.entries = append(a.entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *tarArchive) Entries() []archiveEntry {
+	return a.entries
+}
+
+func (a *tarArchive) Walk(fn func(entry archiveEntry, r io.Reader) error) error {
+	i := 0
+	return a.read(func(header *tar.Header, reader *tar.Reader) error {
+		entry := a.entries[i]
+		i++
+		if entry.IsDir() {
+			return fn(entry, nil)
+		}
+		return fn(entry, reader)
+	})
+}
+
+func (a *tarArchive) Close() error {
+	return nil
+}
+
+// read Decompresses the tarball and calls fn for each entry. Hard links are rejected here since, unlike symlinks,
+// nothing in their mode marks them as links.
+func (a *tarArchive) read(fn func(header *tar.Header, reader *tar.Reader) error) error {
+	file, err := os.Open(a.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	decompressed, err := a.decompress(file)
+	if err != nil {
+		return err
+	}
+	defer decompressed.Close()
+
+	reader := tar.NewReader(decompressed)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeXGlobalHeader:
+			continue
+		case tar.TypeLink:
+			return &UnsafePathError{Entry: header.Name, Reason: "hard links are not allowed"}
+		}
+
+		if err := fn(header, reader); err != nil {
+			return err
+		}
+	}
+}
+
+func (a *tarArchive) decompress(r io.Reader) (io.ReadCloser, error) {
+	if a.format == FormatTarZstd {
+		decoder, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	}
+	return gzip.NewReader(r)
+}
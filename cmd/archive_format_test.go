@@ -0,0 +1,235 @@
+package cmd
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// createTestTar Creates a compressed tarball with entries written in order. Entries with a symlink mode are written as
+// symlinks to their content and entries with a mode of os.ModeType as hard links to their content.
+func createTestTar(t *testing.T, format ArchiveFormat, entries []testZipEntry) string {
+	t.Helper()
+	file, err := os.CreateTemp(t.TempDir(), "test-*.tar")
+	require.NoError(t, err)
+	defer file.Close()
+
+	var compressed io.WriteCloser
+	if format == FormatTarZstd {
+		compressed, err = zstd.NewWriter(file)
+		require.NoError(t, err)
+	} else {
+		compressed = gzip.NewWriter(file)
+	}
+
+	writer := tar.NewWriter(compressed)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: 0644, Size: int64(len(entry.content)), Typeflag: tar.TypeReg, ModTime: entry.modified}
+		switch {
+		case entry.mode.IsRegular() && entry.mode != 0:
+			header.Mode = int64(entry.mode.Perm())
+		case entry.mode == os.ModeType:
+			header.Typeflag, header.Linkname, header.Size = tar.TypeLink, entry.content, 0
+		case entry.mode&os.ModeSymlink != 0:
+			header.Typeflag, header.Linkname, header.Size = tar.TypeSymlink, entry.content, 0
+		case entry.mode.IsDir():
+			header.Typeflag, header.Mode = tar.TypeDir, 0755
+		}
+
+		require.NoError(t, writer.WriteHeader(header))
+		if header.Typeflag == tar.TypeReg {
+			_, err := writer.Write([]byte(entry.content))
+			require.NoError(t, err)
+		}
+	}
+
+	require.NoError(t, writer.Close())
+	require.NoError(t, compressed.Close())
+	return file.Name()
+}
+
+// createTestArchive Creates an archive containing Mod/Mod.dll and Mod/Assets/a.png in the given format. There's no 7z
+// writer for Go so 7z archives are copied from a fixture with the same contents.
+func createTestArchive(t *testing.T, format ArchiveFormat) string {
+	t.Helper()
+	switch format {
+	case FormatZip:
+		return createTestZipEntries(t, []testZipEntry{
+			{name: "Mod/", mode: os.ModeDir | 0755},
+			{name: "Mod/Mod.dll", content: "dll"},
+			{name: "Mod/Assets/a.png", content: "png"},
+		})
+	case Format7z:
+		data, err := os.ReadFile(filepath.Join("testdata", "mod.7z"))
+		require.NoError(t, err)
+		path := filepath.Join(t.TempDir(), "mod.7z")
+		require.NoError(t, os.WriteFile(path, data, 0644))
+		return path
+	}
+	return createTestTar(t, format, []testZipEntry{
+		{name: "Mod/", mode: os.ModeDir | 0755},
+		{name: "Mod/Mod.dll", content: "dll"},
+		{name: "Mod/Assets/a.png", content: "png"},
+	})
+}
+
+var testFormats = []ArchiveFormat{FormatZip, FormatTarGzip, FormatTarZstd, Format7z}
+
+func TestDetectArchiveFormat(t *testing.T) {
+	for _, format := range testFormats {
+		t.Run(string(format), func(t *testing.T) {
+			// The extension is deliberately wrong to make sure only the contents are used
+			path := filepath.Join(t.TempDir(), "Mod.zip.txt")
+			require.NoError(t, os.Rename(createTestArchive(t, format), path))
+
+			got, err := DetectArchiveFormat(path)
+			require.NoError(t, err)
+			assert.Equal(t, format, got)
+		})
+	}
+
+	t.Run("unsupported", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "Mod.zip")
+		require.NoError(t, os.WriteFile(path, []byte("not an archive"), 0644))
+
+		_, err := DetectArchiveFormat(path)
+		var unsupportedErr *UnsupportedFormatError
+		assert.ErrorAs(t, err, &unsupportedErr)
+	})
+
+	t.Run("empty file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "Mod.zip")
+		require.NoError(t, os.WriteFile(path, nil, 0644))
+
+		_, err := DetectArchiveFormat(path)
+		var unsupportedErr *UnsupportedFormatError
+		assert.ErrorAs(t, err, &unsupportedErr)
+	})
+}
+
+func TestArchiveFormats_InstallAndRemove(t *testing.T) {
+	for _, format := range testFormats {
+		for _, withManifest := range []bool{true, false} {
+			name := string(format) + "/manifest"
+			if !withManifest {
+				name = string(format) + "/archive"
+			}
+
+			t.Run(name, func(t *testing.T) {
+				destDir := t.TempDir()
+				archivePath := filepath.Join(destDir, "Mod.archive")
+				require.NoError(t, os.Rename(createTestArchive(t, format), archivePath))
+
+				a := &Archive{ZipFilePath: archivePath, Destination: destDir}
+				require.NoError(t, a.UnzipFile())
+				assertFileContent(t, filepath.Join(destDir, "Mod/Mod.dll"), "dll")
+				assertFileContent(t, filepath.Join(destDir, "Mod/Assets/a.png"), "png")
+
+				if !withManifest {
+					require.NoError(t, os.RemoveAll(filepath.Join(destDir, workDirName)))
+				}
+
+				report, err := a.RemoveFilesFromZip()
+				require.NoError(t, err)
+				assert.Contains(t, report.Removed, "Mod/Mod.dll")
+				assert.Contains(t, report.Removed, "Mod/Assets/a.png")
+				for _, name := range []string{"Mod/Mod.dll", "Mod/Assets/a.png", "Mod.archive"} {
+					_, err := os.Stat(filepath.Join(destDir, name))
+					assert.True(t, os.IsNotExist(err), "%s should have been removed", name)
+				}
+			})
+		}
+	}
+}
+
+func TestUnzipFile_UnsafeTarEntries(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry testZipEntry
+	}{
+		{name: "parent traversal", entry: testZipEntry{name: "../evil.txt", content: "evil"}},
+		{name: "absolute path", entry: testZipEntry{name: "/tmp/evil.txt", content: "evil"}},
+		{name: "symlink", entry: testZipEntry{name: "link", content: "../../etc/passwd", mode: os.ModeSymlink | 0777}},
+		{name: "hard link", entry: testZipEntry{name: "link", content: "/etc/passwd", mode: os.ModeType}},
+	}
+
+	for _, format := range []ArchiveFormat{FormatTarGzip, FormatTarZstd} {
+		for _, tt := range tests {
+			t.Run(string(format)+"/"+tt.name, func(t *testing.T) {
+				destDir := t.TempDir()
+				archivePath := createTestTar(t, format, []testZipEntry{{name: "safe.txt", content: "safe"}, tt.entry})
+
+				a := &Archive{ZipFilePath: archivePath, Destination: destDir}
+				err := a.UnzipFile()
+				var unsafePathErr *UnsafePathError
+				require.True(t, errors.As(err, &unsafePathErr), "UnzipFile() error = %v, want *UnsafePathError", err)
+				assert.Equal(t, tt.entry.name, unsafePathErr.Entry)
+
+				_, err = os.Stat(filepath.Join(destDir, "safe.txt"))
+				assert.True(t, os.IsNotExist(err), "safe.txt should not have been extracted from a rejected archive")
+			})
+		}
+	}
+}
+
+func TestSafeFileMode(t *testing.T) {
+	tests := []struct {
+		mode os.FileMode
+		want os.FileMode
+	}{
+		{mode: 0755, want: 0755},
+		{mode: 0644, want: 0644},
+		{mode: 0777, want: 0755},
+		{mode: 0666, want: 0644},
+		{mode: 0444, want: 0644},
+		{mode: 0600, want: 0600},
+		{mode: os.ModeSetuid | 0755, want: 0755},
+		{mode: 0, want: 0644},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, safeFileMode(tt.mode), "safeFileMode(%v)", tt.mode)
+	}
+}
+
+func TestUnzipFile_PreservesModesAndTimes(t *testing.T) {
+	modified := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	entries := []testZipEntry{
+		{name: "Mod/helper", content: "#!/bin/sh", mode: 0755, modified: modified},
+		{name: "Mod/Mod.dll", content: "dll", mode: 0666, modified: modified},
+		{name: "Mod/Mod.cfg", content: "cfg"},
+	}
+
+	for _, format := range []ArchiveFormat{FormatZip, FormatTarGzip} {
+		t.Run(string(format), func(t *testing.T) {
+			destDir := t.TempDir()
+			archivePath := filepath.Join(destDir, "Mod.archive")
+			if format == FormatZip {
+				require.NoError(t, os.Rename(createTestZipEntries(t, entries), archivePath))
+			} else {
+				require.NoError(t, os.Rename(createTestTar(t, format, entries), archivePath))
+			}
+
+			a := &Archive{ZipFilePath: archivePath, Destination: destDir}
+			require.NoError(t, a.UnzipFile())
+
+			info, err := os.Stat(filepath.Join(destDir, "Mod", "helper"))
+			require.NoError(t, err)
+			assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+			assert.True(t, modified.Equal(info.ModTime()), "ModTime() = %v, want %v", info.ModTime(), modified)
+
+			info, err = os.Stat(filepath.Join(destDir, "Mod", "Mod.dll"))
+			require.NoError(t, err)
+			assert.Equal(t, os.FileMode(0644), info.Mode().Perm(), "group and world write should be masked")
+			assert.True(t, modified.Equal(info.ModTime()))
+		})
+	}
+}
@@ -2,9 +2,12 @@ package cmd
 
 import (
 	"archive/zip"
+	"bytes"
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func createTestZip(t *testing.T, files map[string]string) string {
@@ -177,7 +180,7 @@ func TestRemoveFilesFromZip(t *testing.T) {
 				Destination: destDir,
 			}
 
-			err = a.RemoveFilesFromZip()
+			_, err = a.RemoveFilesFromZip()
 			if (err != nil) != tt.wantErr {
 				t.Errorf("RemoveFilesFromZip() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -204,3 +207,307 @@ func TestRemoveFilesFromZip(t *testing.T) {
 		})
 	}
 }
+
+type testZipEntry struct {
+	name     string
+	content  string
+	mode     os.FileMode
+	modified time.Time
+}
+
+// createTestZipEntries Creates a zip with entries written in order and with explicit modes so that hostile entries
+// (symlinks, absolute paths etc...) can be crafted.
+func createTestZipEntries(t *testing.T, entries []testZipEntry) string {
+	tmpZip, err := os.CreateTemp("", "test-*.zip")
+	if err != nil {
+		t.Fatalf("Failed to create temp zip: %v", err)
+	}
+
+	zipWriter := zip.NewWriter(tmpZip)
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.name, Method: zip.Deflate, Modified: entry.modified}
+		if entry.mode != 0 {
+			header.SetMode(entry.mode)
+		}
+		f, err := zipWriter.CreateHeader(header)
+		if err != nil {
+			t.Fatalf("Failed to create file in zip: %v", err)
+		}
+		_, err = f.Write([]byte(entry.content))
+		if err != nil {
+			t.Fatalf("Failed to write content to zip file: %v", err)
+		}
+	}
+
+	if err = zipWriter.Close(); err != nil {
+		t.Fatalf("Failed to close zip writer: %v", err)
+	}
+	if err = tmpZip.Close(); err != nil {
+		t.Fatalf("Failed to close temp file: %v", err)
+	}
+
+	return tmpZip.Name()
+}
+
+var hostileEntries = []struct {
+	name  string
+	entry testZipEntry
+}{
+	{name: "parent traversal", entry: testZipEntry{name: "../evil.txt", content: "evil"}},
+	{name: "nested parent traversal", entry: testZipEntry{name: "dir/../../evil.txt", content: "evil"}},
+	{name: "absolute path", entry: testZipEntry{name: "/tmp/evil.txt", content: "evil"}},
+	{name: "windows parent traversal", entry: testZipEntry{name: `..\evil.txt`, content: "evil"}},
+	{name: "windows nested parent traversal", entry: testZipEntry{name: `dir\..\..\evil.txt`, content: "evil"}},
+	{name: "windows drive letter", entry: testZipEntry{name: `C:\evil.txt`, content: "evil"}},
+	{name: "symlink", entry: testZipEntry{name: "link", content: "../../etc/passwd", mode: os.ModeSymlink | 0777}},
+}
+
+func TestUnzipFile_UnsafeEntries(t *testing.T) {
+	for _, tt := range hostileEntries {
+		t.Run(tt.name, func(t *testing.T) {
+			parentDir, err := os.MkdirTemp("", "test-parent-*")
+			if err != nil {
+				t.Fatalf("Failed to create temp dir: %v", err)
+			}
+			defer os.RemoveAll(parentDir)
+
+			destDir := filepath.Join(parentDir, "plugins")
+			if err := os.MkdirAll(destDir, 0755); err != nil {
+				t.Fatalf("Failed to create destination dir: %v", err)
+			}
+
+			// A safe entry is placed before the hostile one to ensure nothing is written when the archive is rejected.
+			zipPath := createTestZipEntries(t, []testZipEntry{{name: "safe.txt", content: "safe"}, tt.entry})
+			defer os.Remove(zipPath)
+
+			a := &Archive{
+				ZipFilePath: zipPath,
+				Destination: destDir,
+			}
+
+			err = a.UnzipFile()
+			var unsafePathErr *UnsafePathError
+			if !errors.As(err, &unsafePathErr) {
+				t.Fatalf("UnzipFile() error = %v, want *UnsafePathError", err)
+			}
+			if unsafePathErr.Entry != tt.entry.name {
+				t.Errorf("UnsafePathError.Entry = %s, want %s", unsafePathErr.Entry, tt.entry.name)
+			}
+
+			if _, err := os.Stat(filepath.Join(destDir, "safe.txt")); !os.IsNotExist(err) {
+				t.Errorf("safe.txt should not have been extracted from a rejected archive")
+			}
+			if _, err := os.Stat(filepath.Join(parentDir, "evil.txt")); !os.IsNotExist(err) {
+				t.Errorf("evil.txt should not have been written outside the destination")
+			}
+		})
+	}
+}
+
+func TestRemoveFilesFromZip_UnsafeEntries(t *testing.T) {
+	for _, tt := range hostileEntries {
+		t.Run(tt.name, func(t *testing.T) {
+			parentDir, err := os.MkdirTemp("", "test-parent-*")
+			if err != nil {
+				t.Fatalf("Failed to create temp dir: %v", err)
+			}
+			defer os.RemoveAll(parentDir)
+
+			destDir := filepath.Join(parentDir, "plugins")
+			createTestFiles(t, map[string]string{
+				"evil.txt":         "must survive",
+				"link":             "must survive",
+				"plugins/safe.txt": "must survive",
+			}, parentDir)
+
+			zipPath := createTestZipEntries(t, []testZipEntry{{name: "safe.txt", content: "safe"}, tt.entry})
+			defer os.Remove(zipPath)
+
+			a := &Archive{
+				ZipFilePath: zipPath,
+				Destination: destDir,
+			}
+
+			_, err = a.RemoveFilesFromZip()
+			var unsafePathErr *UnsafePathError
+			if !errors.As(err, &unsafePathErr) {
+				t.Fatalf("RemoveFilesFromZip() error = %v, want *UnsafePathError", err)
+			}
+
+			for _, name := range []string{"evil.txt", "link", "plugins/safe.txt"} {
+				if _, err := os.Stat(filepath.Join(parentDir, name)); err != nil {
+					t.Errorf("%s should not have been removed: %v", name, err)
+				}
+			}
+		})
+	}
+}
+
+func TestResolveEntryPath(t *testing.T) {
+	destDir := filepath.Join(os.TempDir(), "plugins")
+
+	path, err := resolveEntryPath(destDir, "dir/../nested.txt")
+	if err != nil {
+		t.Fatalf("resolveEntryPath() unexpected error: %v", err)
+	}
+	if path != filepath.Join(destDir, "nested.txt") {
+		t.Errorf("resolveEntryPath() = %s, want %s", path, filepath.Join(destDir, "nested.txt"))
+	}
+
+	path, err = resolveEntryPath(destDir, `dir\nested.txt`)
+	if err != nil {
+		t.Fatalf("resolveEntryPath() unexpected error: %v", err)
+	}
+	if path != filepath.Join(destDir, "dir", "nested.txt") {
+		t.Errorf("resolveEntryPath() = %s, want %s", path, filepath.Join(destDir, "dir", "nested.txt"))
+	}
+
+	// A sibling directory sharing the destination as a prefix must not be treated as inside the destination.
+	_, err = resolveEntryPath(destDir, "../plugins-evil/foo.txt")
+	var unsafePathErr *UnsafePathError
+	if !errors.As(err, &unsafePathErr) {
+		t.Errorf("resolveEntryPath() error = %v, want *UnsafePathError", err)
+	}
+}
+
+func TestUnzipFile_ReservedWorkDir(t *testing.T) {
+	destDir := t.TempDir()
+	zipPath := createTestZip(t, map[string]string{".hearthhub/rollback-1/0": "evil"})
+	defer os.Remove(zipPath)
+
+	a := &Archive{ZipFilePath: zipPath, Destination: destDir}
+	var unsafePathErr *UnsafePathError
+	if err := a.UnzipFile(); !errors.As(err, &unsafePathErr) {
+		t.Fatalf("UnzipFile() error = %v, want *UnsafePathError", err)
+	}
+}
+
+func TestUnzipFile_CleansUpWorkDir(t *testing.T) {
+	destDir := t.TempDir()
+	zipPath := createTestZip(t, map[string]string{"Mod/Mod.dll": "dll"})
+	defer os.Remove(zipPath)
+
+	a := &Archive{ZipFilePath: zipPath, Destination: destDir}
+	if err := a.UnzipFile(); err != nil {
+		t.Fatalf("UnzipFile() unexpected error: %v", err)
+	}
+
+	// Only the manifests should remain once the staging and rollback directories are cleaned up
+	entries, err := os.ReadDir(filepath.Join(destDir, workDirName))
+	if err != nil {
+		t.Fatalf("Failed to read work dir: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name() != manifestDirName {
+		t.Errorf("%s should only contain %s after install, got: %v", workDirName, manifestDirName, entries)
+	}
+	if _, err := os.Stat(stagingRoot(destDir)); !os.IsNotExist(err) {
+		t.Errorf("%s should have been cleaned up after install", stagingRoot(destDir))
+	}
+}
+
+func TestUnzipFile_RemovesStaleInstallDirs(t *testing.T) {
+	destDir := t.TempDir()
+	createTestFiles(t, map[string]string{
+		filepath.Join(workDirName, "rollback-1", "0"):      "replaced dll",
+		filepath.Join(workDirName, "staging-1", "Mod.dll"): "staged dll",
+	}, destDir)
+	createTestFiles(t, map[string]string{"rollback-2/0": "replaced dll", "staging-2/Mod.dll": "staged dll"}, stagingRoot(destDir))
+
+	zipPath := createTestZip(t, map[string]string{"Mod/Mod.dll": "dll"})
+	defer os.Remove(zipPath)
+
+	a := &Archive{ZipFilePath: zipPath, Destination: destDir}
+	if err := a.UnzipFile(); err != nil {
+		t.Fatalf("UnzipFile() unexpected error: %v", err)
+	}
+
+	for _, dir := range []string{filepath.Join(destDir, workDirName, "rollback-1"), filepath.Join(destDir, workDirName, "staging-1"), stagingRoot(destDir)} {
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Errorf("stale install directory %s should have been removed", dir)
+		}
+	}
+}
+
+func TestUnzipFile_RollbackOnInstallFailure(t *testing.T) {
+	destDir := t.TempDir()
+	createTestFiles(t, map[string]string{
+		"Mod/Mod.dll":         "old dll",
+		"conflict/keep.txt":   "existing directory",
+		"unrelated/Other.dll": "other mod",
+	}, destDir)
+
+	// conflict is a non-empty directory on disk so installing a file over it fails after Mod.dll has been replaced
+	zipPath := createTestZipEntries(t, []testZipEntry{
+		{name: "Mod/Mod.dll", content: "new dll"},
+		{name: "Mod/New/added.txt", content: "new file"},
+		{name: "conflict", content: "file over directory"},
+	})
+	defer os.Remove(zipPath)
+
+	a := &Archive{ZipFilePath: zipPath, Destination: destDir}
+	if err := a.UnzipFile(); err == nil {
+		t.Fatal("UnzipFile() expected error installing a file over a directory")
+	}
+
+	assertFileContent(t, filepath.Join(destDir, "Mod/Mod.dll"), "old dll")
+	assertFileContent(t, filepath.Join(destDir, "conflict/keep.txt"), "existing directory")
+	assertFileContent(t, filepath.Join(destDir, "unrelated/Other.dll"), "other mod")
+
+	if _, err := os.Stat(filepath.Join(destDir, "Mod/New")); !os.IsNotExist(err) {
+		t.Errorf("directory created by the failed install should have been removed")
+	}
+	if _, err := os.Stat(filepath.Join(destDir, workDirName)); !os.IsNotExist(err) {
+		t.Errorf("%s should have been cleaned up after rollback", workDirName)
+	}
+}
+
+func TestUnzipFile_CorruptEntryLeavesDestinationUntouched(t *testing.T) {
+	destDir := t.TempDir()
+	createTestFiles(t, map[string]string{"Mod.dll": "old dll"}, destDir)
+
+	zipPath := createTestZipEntries(t, []testZipEntry{
+		{name: "Mod.dll", content: "new dll"},
+		{name: "Corrupt.dll", content: "corrupt me"},
+	})
+	defer os.Remove(zipPath)
+
+	// Flip the stored bytes of the second entry so its CRC-32 no longer matches
+	data, err := os.ReadFile(zipPath)
+	if err != nil {
+		t.Fatalf("Failed to read zip: %v", err)
+	}
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("Failed to open zip: %v", err)
+	}
+	offset, err := reader.File[1].DataOffset()
+	if err != nil {
+		t.Fatalf("Failed to find entry data: %v", err)
+	}
+	data[offset] ^= 0xff
+	if err := os.WriteFile(zipPath, data, 0644); err != nil {
+		t.Fatalf("Failed to write zip: %v", err)
+	}
+
+	a := &Archive{ZipFilePath: zipPath, Destination: destDir}
+	if err := a.UnzipFile(); err == nil {
+		t.Fatal("UnzipFile() expected error for corrupt entry")
+	}
+
+	assertFileContent(t, filepath.Join(destDir, "Mod.dll"), "old dll")
+	if _, err := os.Stat(filepath.Join(destDir, "Corrupt.dll")); !os.IsNotExist(err) {
+		t.Errorf("Corrupt.dll should not have been installed")
+	}
+}
+
+func assertFileContent(t *testing.T, path, want string) {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Errorf("Failed to read %s: %v", path, err)
+		return
+	}
+	if string(content) != want {
+		t.Errorf("File %s content = %s, want %s", path, content, want)
+	}
+}
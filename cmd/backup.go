@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// What a backup op uploads.
+const (
+	BackupWorlds  = "worlds"
+	BackupConfig  = "config"
+	BackupPlugins = "plugins"
+)
+
+// BackupKey Returns the S3 key a user's backed up file is stored under. World files sit directly under
+// valheim-backups-auto/{discordId}/ which is the S3Key recorded for world and backup files, with config and plugin
+// files under config/ and plugins/ within it.
+func BackupKey(discordId string, elem ...string) string {
+	return path.Join(append([]string{"valheim-backups-auto", discordId}, elem...)...)
+}
+
+// backupSource A file on the PVC and the key a backup uploads it to.
+type backupSource struct {
+	path string
+	key  string
+}
+
+// backupSources Returns every file the backup selects. For world backups the prefix names a world, with or without its
+// .db or .fwl extension, and both files of the pair are selected. For config and plugin backups the prefix is a file or
+// directory relative to the config or plugins directory. Everything is selected when the prefix is empty.
+func (f *FileManager) backupSources() ([]backupSource, error) {
+	switch f.Backup {
+	case BackupWorlds:
+		return f.worldBackupSources()
+	case BackupConfig:
+		return f.treeBackupSources(CONFIG_DIR)
+	case BackupPlugins:
+		return f.treeBackupSources(PLUGINS_DIR)
+	}
+	return nil, fmt.Errorf("invalid backup %q. Must be one of: %s, %s, %s", f.Backup, BackupWorlds, BackupConfig, BackupPlugins)
+}
+
+// worldBackupSources Selects the .db and .fwl files in the backups directory.
+func (f *FileManager) worldBackupSources() ([]backupSource, error) {
+	world := strings.TrimSuffix(strings.TrimSuffix(filepath.Base(f.Prefix), ".db"), ".fwl")
+	files, err := f.ListFiles(BACKUPS_DIR, func(fileName string) bool {
+		ext := filepath.Ext(fileName)
+		return (ext == ".db" || ext == ".fwl") && (f.Prefix == "" || strings.TrimSuffix(fileName, ext) == world)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(files) == 0 && f.Prefix != "" {
+		return nil, fmt.Errorf("world %s not found in %s", world, BACKUPS_DIR)
+	}
+	if len(files) == 1 && f.Prefix != "" {
+		log.Warnf("only found %s for world %s, backing up without its pair", files[0].Name(), world)
+	}
+
+	var sources []backupSource
+	for _, file := range files {
+		sources = append(sources, backupSource{path: filepath.Join(BACKUPS_DIR, file.Name()), key: BackupKey(f.DiscordId, file.Name())})
+	}
+	return sources, nil
+}
+
+// treeBackupSources Selects the regular files under the prefix within root. The file manager's own work directory and
+// anything which isn't a regular file, like symlinks, are skipped.
+func (f *FileManager) treeBackupSources(root string) ([]backupSource, error) {
+	start, err := resolveEntryPath(root, f.Prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var sources []backupSource
+	err = filepath.WalkDir(start, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() {
+			if entry.Name() == workDirName {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !entry.Type().IsRegular() {
+			log.Warnf("skipping backup of %s: not a regular file", path)
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		sources = append(sources, backupSource{path: path, key: BackupKey(f.DiscordId, f.Backup, filepath.ToSlash(rel))})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files to back up in %s: %w", start, err)
+	}
+	return sources, nil
+}
+
+// Backup Uploads the world, config or plugin files selected by the file manager to the user's backups in S3. Files are
+// uploaded one at a time with UploadFile. The uploads which succeeded are returned along with the first error.
+func (s *S3Client) Backup(fileManager *FileManager) ([]*UploadResult, error) {
+	sources, err := fileManager.backupSources()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sources) == 0 {
+		log.Warnf("nothing to back up for %s", fileManager.Backup)
+	}
+
+	var results []*UploadResult
+	for _, source := range sources {
+		result, err := s.UploadFile(source.path, source.key)
+		if err != nil {
+			return results, fmt.Errorf("failed to back up %s: %w", source.path, err)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// makeTestBackupDirs Points the worlds, config and plugins directories at temp directories with a few files in each.
+func makeTestBackupDirs(t *testing.T) {
+	backupsDir, pluginsDir, configDir := BACKUPS_DIR, PLUGINS_DIR, CONFIG_DIR
+	t.Cleanup(func() {
+		BACKUPS_DIR, PLUGINS_DIR, CONFIG_DIR = backupsDir, pluginsDir, configDir
+	})
+	BACKUPS_DIR, PLUGINS_DIR, CONFIG_DIR = t.TempDir(), t.TempDir(), t.TempDir()
+
+	files := map[string]string{
+		filepath.Join(BACKUPS_DIR, "MyWorld.db"):                       "db",
+		filepath.Join(BACKUPS_DIR, "MyWorld.fwl"):                      "fwl",
+		filepath.Join(BACKUPS_DIR, "Other.db"):                         "db",
+		filepath.Join(BACKUPS_DIR, "Other.fwl"):                        "fwl",
+		filepath.Join(BACKUPS_DIR, "Lonely.db"):                        "db",
+		filepath.Join(BACKUPS_DIR, "MyWorld.db.old"):                   "old",
+		filepath.Join(CONFIG_DIR, "BepInEx.cfg"):                       "cfg",
+		filepath.Join(CONFIG_DIR, "ValheimPlus", "valheim_plus.cfg"):   "cfg",
+		filepath.Join(PLUGINS_DIR, "Mod.dll"):                          "dll",
+		filepath.Join(PLUGINS_DIR, "Pack", "Pack.dll"):                 "dll",
+		filepath.Join(PLUGINS_DIR, workDirName, "manifests", "m.json"): "{}",
+	}
+	for path, content := range files {
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+}
+
+func TestBackupSources(t *testing.T) {
+	makeTestBackupDirs(t)
+
+	tests := []struct {
+		name     string
+		backup   string
+		prefix   string
+		wantKeys []string
+		wantErr  bool
+	}{
+		{
+			name:     "all worlds",
+			backup:   BackupWorlds,
+			wantKeys: []string{"valheim-backups-auto/123/Lonely.db", "valheim-backups-auto/123/MyWorld.db", "valheim-backups-auto/123/MyWorld.fwl", "valheim-backups-auto/123/Other.db", "valheim-backups-auto/123/Other.fwl"},
+		},
+		{
+			name:     "one world",
+			backup:   BackupWorlds,
+			prefix:   "MyWorld",
+			wantKeys: []string{"valheim-backups-auto/123/MyWorld.db", "valheim-backups-auto/123/MyWorld.fwl"},
+		},
+		{
+			name:     "one world by file name",
+			backup:   BackupWorlds,
+			prefix:   "MyWorld.fwl",
+			wantKeys: []string{"valheim-backups-auto/123/MyWorld.db", "valheim-backups-auto/123/MyWorld.fwl"},
+		},
+		{
+			name:     "world without its pair",
+			backup:   BackupWorlds,
+			prefix:   "Lonely",
+			wantKeys: []string{"valheim-backups-auto/123/Lonely.db"},
+		},
+		{
+			name:    "missing world",
+			backup:  BackupWorlds,
+			prefix:  "Missing",
+			wantErr: true,
+		},
+		{
+			name:     "all config",
+			backup:   BackupConfig,
+			wantKeys: []string{"valheim-backups-auto/123/config/BepInEx.cfg", "valheim-backups-auto/123/config/ValheimPlus/valheim_plus.cfg"},
+		},
+		{
+			name:     "config directory",
+			backup:   BackupConfig,
+			prefix:   "ValheimPlus",
+			wantKeys: []string{"valheim-backups-auto/123/config/ValheimPlus/valheim_plus.cfg"},
+		},
+		{
+			name:     "plugins skip the work directory",
+			backup:   BackupPlugins,
+			wantKeys: []string{"valheim-backups-auto/123/plugins/Mod.dll", "valheim-backups-auto/123/plugins/Pack/Pack.dll"},
+		},
+		{
+			name:     "single plugin",
+			backup:   BackupPlugins,
+			prefix:   "Mod.dll",
+			wantKeys: []string{"valheim-backups-auto/123/plugins/Mod.dll"},
+		},
+		{
+			name:    "prefix escaping the plugins directory",
+			backup:  BackupPlugins,
+			prefix:  "../../etc",
+			wantErr: true,
+		},
+		{
+			name:    "missing plugin",
+			backup:  BackupPlugins,
+			prefix:  "Missing.dll",
+			wantErr: true,
+		},
+		{
+			name:    "invalid backup",
+			backup:  "mods",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fileManager := &FileManager{DiscordId: "123", Op: BACKUP, Backup: tt.backup, Prefix: tt.prefix}
+			sources, err := fileManager.backupSources()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			var keys []string
+			for _, source := range sources {
+				keys = append(keys, source.key)
+				assert.FileExists(t, source.path)
+			}
+			sort.Strings(keys)
+			assert.Equal(t, tt.wantKeys, keys)
+		})
+	}
+}
+
+func TestS3Client_Backup(t *testing.T) {
+	makeTestBackupDirs(t)
+	store := newFakeObjectStore()
+	s3Client := &S3Client{BucketName: "test-bucket", PartSize: minUploadPartSize, Concurrency: 2, client: store}
+
+	results, err := s3Client.Backup(&FileManager{DiscordId: "123", Op: BACKUP, Backup: BackupWorlds, Prefix: "MyWorld"})
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, []byte("db"), store.objects["valheim-backups-auto/123/MyWorld.db"])
+	assert.Equal(t, []byte("fwl"), store.objects["valheim-backups-auto/123/MyWorld.fwl"])
+	for _, result := range results {
+		assert.Equal(t, result.SHA256, store.metadata[result.Key][sha256MetadataKey])
+	}
+}
+
+func TestBackupKey(t *testing.T) {
+	assert.Equal(t, "valheim-backups-auto/123/MyWorld.db", BackupKey("123", "MyWorld.db"))
+	assert.Equal(t, "valheim-backups-auto/123/config/BepInEx.cfg", BackupKey("123", BackupConfig, "BepInEx.cfg"))
+}
@@ -0,0 +1,300 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultCacheMaxSizeMb = 1024
+
+	// staleCacheFileAge How old a partially written cache file has to be before pruning removes it. Anything younger may
+	// still be being written by another Job.
+	staleCacheFileAge = time.Hour
+)
+
+// DownloadCache A directory on the PVC holding previously downloaded objects keyed by their bucket, key and ETag so
+// reinstalling a mod copies it from disk rather than downloading the same bytes from S3 again. A cached copy is only
+// used while its ETag matches the object in S3. Entries are evicted least recently used first once the cache grows
+// past MaxSize.
+type DownloadCache struct {
+	Dir     string
+	MaxSize int64 // Bytes. A value of 0 doesn't limit the size of the cache
+}
+
+// cacheEntry The metadata stored alongside each cached object.
+type cacheEntry struct {
+	Bucket   string    `json:"bucket"`
+	Key      string    `json:"key"`
+	ETag     string    `json:"etag"`
+	Size     int64     `json:"size"`
+	SHA256   string    `json:"sha256"`
+	CachedAt time.Time `json:"cachedAt"`
+}
+
+// CachePruneReport Describes what pruning the download cache removed.
+type CachePruneReport struct {
+	Stale   int   // Entries whose object has since changed or been deleted in S3
+	Evicted int   // Entries removed to bring the cache under its maximum size
+	Freed   int64 // Bytes
+	Size    int64 // Bytes left in the cache
+}
+
+// MakeDownloadCache Creates the download cache configured by the DOWNLOAD_CACHE_DIR and DOWNLOAD_CACHE_MAX_SIZE_MB
+// environment variables. Returns nil, disabling the cache, when DOWNLOAD_CACHE_DIR is unset.
+func MakeDownloadCache() *DownloadCache {
+	dir := os.Getenv("DOWNLOAD_CACHE_DIR")
+	if dir == "" {
+		return nil
+	}
+
+	return &DownloadCache{
+		Dir:     dir,
+		MaxSize: getEnvInt64("DOWNLOAD_CACHE_MAX_SIZE_MB", defaultCacheMaxSizeMb) * 1024 * 1024,
+	}
+}
+
+// cacheId Identifies a version of an object in the cache.
+func cacheId(bucket, key, etag string) string {
+	sum := sha256.Sum256([]byte(bucket + "\x00" + key + "\x00" + etag))
+	return hex.EncodeToString(sum[:])
+}
+
+// paths Returns the path of the cached object's contents and of its metadata.
+func (c *DownloadCache) paths(id string) (string, string) {
+	return filepath.Join(c.Dir, id+".blob"), filepath.Join(c.Dir, id+".json")
+}
+
+// get Returns the cached copy of the object and the path to its contents or nil when there isn't one. A hit marks the
+// entry as the most recently used.
+func (c *DownloadCache) get(bucket, key, etag string) (*cacheEntry, string) {
+	id := cacheId(bucket, key, etag)
+	blob, meta := c.paths(id)
+	entry, err := readCacheEntry(meta)
+	if err != nil {
+		return nil, ""
+	}
+
+	info, err := os.Stat(blob)
+	if err != nil || info.Size() != entry.Size {
+		log.Warnf("cached copy of s3://%s/%s is incomplete, removing it", bucket, key)
+		c.remove(id)
+		return nil, ""
+	}
+
+	now := time.Now()
+	if err := os.Chtimes(blob, now, now); err != nil {
+		log.Warnf("failed to mark cached copy of s3://%s/%s as used: %v", bucket, key, err)
+	}
+	return entry, blob
+}
+
+// put Copies the file at path into the cache and then evicts the least recently used entries to keep the cache under
+// its maximum size.
+func (c *DownloadCache) put(entry *cacheEntry, path string) error {
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return err
+	}
+
+	id := cacheId(entry.Bucket, entry.Key, entry.ETag)
+	blob, meta := c.paths(id)
+	_, err := writeFileAtomic(blob, func(file *os.File) (int64, error) {
+		src, err := os.Open(path)
+		if err != nil {
+			return 0, err
+		}
+		defer src.Close()
+		return io.Copy(file, src)
+	})
+	if err != nil {
+		return err
+	}
+
+	entry.CachedAt = time.Now()
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// The metadata is written last so an entry is never read before its contents are in place
+	_, err = writeFileAtomic(meta, func(file *os.File) (int64, error) {
+		n, err := file.Write(data)
+		return int64(n), err
+	})
+	if err != nil {
+		c.remove(id)
+		return err
+	}
+
+	_, _, err = c.evict()
+	return err
+}
+
+// remove Removes an entry from the cache returning the number of bytes freed.
+func (c *DownloadCache) remove(id string) int64 {
+	blob, meta := c.paths(id)
+	var freed int64
+	if info, err := os.Stat(blob); err == nil {
+		freed = info.Size()
+	}
+	os.Remove(meta)
+	os.Remove(blob)
+	return freed
+}
+
+// cachedObject An entry found in the cache directory.
+type cachedObject struct {
+	id       string
+	entry    *cacheEntry
+	size     int64
+	lastUsed time.Time
+}
+
+// list Returns every complete entry in the cache ordered from least to most recently used.
+func (c *DownloadCache) list() ([]cachedObject, error) {
+	metas, err := filepath.Glob(filepath.Join(c.Dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []cachedObject
+	for _, meta := range metas {
+		id := strings.TrimSuffix(filepath.Base(meta), ".json")
+		entry, err := readCacheEntry(meta)
+		if err != nil {
+			continue
+		}
+
+		blob, _ := c.paths(id)
+		info, err := os.Stat(blob)
+		if err != nil {
+			continue
+		}
+		objects = append(objects, cachedObject{id: id, entry: entry, size: info.Size(), lastUsed: info.ModTime()})
+	}
+
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].lastUsed.Before(objects[j].lastUsed)
+	})
+	return objects, nil
+}
+
+// evict Removes the least recently used entries until the cache is no larger than its maximum size. Returns the number
+// of entries evicted and the number of bytes freed.
+func (c *DownloadCache) evict() (int, int64, error) {
+	objects, err := c.list()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var size int64
+	for _, object := range objects {
+		size += object.size
+	}
+
+	var evicted int
+	var freed int64
+	for _, object := range objects {
+		if c.MaxSize <= 0 || size <= c.MaxSize {
+			break
+		}
+
+		log.Infof("evicting s3://%s/%s (%d bytes) from the download cache", object.entry.Bucket, object.entry.Key, object.size)
+		freed += c.remove(object.id)
+		size -= object.size
+		evicted++
+	}
+	return evicted, freed, nil
+}
+
+// prune Removes every entry which valid reports is no longer the current version of its object along with files left
+// behind by Jobs which were killed while writing to the cache, then evicts entries until the cache is under its maximum
+// size. Entries valid fails to check are kept.
+func (c *DownloadCache) prune(valid func(entry *cacheEntry) (bool, error)) (*CachePruneReport, error) {
+	report := &CachePruneReport{}
+	objects, err := c.list()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, object := range objects {
+		ok, err := valid(object.entry)
+		if err != nil {
+			log.Warnf("failed to check s3://%s/%s, keeping its cached copy: %v", object.entry.Bucket, object.entry.Key, err)
+			continue
+		}
+
+		if !ok {
+			log.Infof("removing stale cached copy of s3://%s/%s", object.entry.Bucket, object.entry.Key)
+			report.Freed += c.remove(object.id)
+			report.Stale++
+		}
+	}
+
+	c.removeOrphans(report)
+
+	evicted, freed, err := c.evict()
+	if err != nil {
+		return nil, err
+	}
+	report.Evicted = evicted
+	report.Freed += freed
+
+	objects, err = c.list()
+	if err != nil {
+		return nil, err
+	}
+	for _, object := range objects {
+		report.Size += object.size
+	}
+	return report, nil
+}
+
+// removeOrphans Removes temporary files and contents without metadata which are older than staleCacheFileAge.
+func (c *DownloadCache) removeOrphans(report *CachePruneReport) {
+	files, err := os.ReadDir(c.Dir)
+	if err != nil {
+		return
+	}
+
+	for _, file := range files {
+		name := file.Name()
+		orphan := strings.HasSuffix(name, ".tmp")
+		if id, ok := strings.CutSuffix(name, ".blob"); ok {
+			_, meta := c.paths(id)
+			_, err := os.Stat(meta)
+			orphan = os.IsNotExist(err)
+		}
+
+		info, err := file.Info()
+		if !orphan || err != nil || time.Since(info.ModTime()) < staleCacheFileAge {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(c.Dir, name)); err == nil {
+			report.Freed += info.Size()
+		}
+	}
+}
+
+func readCacheEntry(path string) (*cacheEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, fmt.Errorf("failed to parse cache entry %s: %w", path, err)
+	}
+	return &entry, nil
+}
@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMakeDownloadCache(t *testing.T) {
+	t.Setenv("DOWNLOAD_CACHE_DIR", "")
+	assert.Nil(t, MakeDownloadCache())
+
+	t.Setenv("DOWNLOAD_CACHE_DIR", "/valheim/.cache")
+	cache := MakeDownloadCache()
+	require.NotNil(t, cache)
+	assert.Equal(t, "/valheim/.cache", cache.Dir)
+	assert.Equal(t, int64(defaultCacheMaxSizeMb*1024*1024), cache.MaxSize)
+
+	t.Setenv("DOWNLOAD_CACHE_MAX_SIZE_MB", "10")
+	assert.Equal(t, int64(10*1024*1024), MakeDownloadCache().MaxSize)
+}
+
+// makeTestCachedClient Creates an S3 client backed by the fake object store with a download cache.
+func makeTestCachedClient(t *testing.T, store *fakeObjectStore, maxSize int64) *S3Client {
+	return &S3Client{
+		BucketName: "test-bucket",
+		Cache:      &DownloadCache{Dir: filepath.Join(t.TempDir(), "cache"), MaxSize: maxSize},
+		client:     store,
+	}
+}
+
+// downloadTestFile Downloads key to a new file in a temporary directory.
+func downloadTestFile(t *testing.T, s3Client *S3Client, key string) (*DownloadResult, string) {
+	t.Helper()
+	destination := filepath.Join(t.TempDir(), filepath.Base(key))
+	result, err := s3Client.DownloadFile(&FileManager{Op: WRITE, Prefix: key, FileName: filepath.Base(key), FileDestinationPath: destination})
+	require.NoError(t, err)
+	return result, destination
+}
+
+func TestDownloadFile_Cache(t *testing.T) {
+	store := newFakeObjectStore()
+	store.put("mods/Mod.zip", []byte("version 1"))
+	s3Client := makeTestCachedClient(t, store, 0)
+
+	result, _ := downloadTestFile(t, s3Client, "mods/Mod.zip")
+	assert.False(t, result.Cached)
+	assert.Equal(t, 1, store.gets)
+
+	// The second download is copied from the cache and still verified against S3's checksum
+	result, destination := downloadTestFile(t, s3Client, "mods/Mod.zip")
+	assert.True(t, result.Cached)
+	assert.Equal(t, ChecksumETag, result.Verified)
+	assert.Equal(t, int64(len("version 1")), result.Bytes)
+	assert.Equal(t, 1, store.gets)
+	assertFileContent(t, destination, "version 1")
+
+	// Replacing the object changes its ETag so the cached copy is no longer used
+	store.put("mods/Mod.zip", []byte("version 2"))
+	result, destination = downloadTestFile(t, s3Client, "mods/Mod.zip")
+	assert.False(t, result.Cached)
+	assert.Equal(t, 2, store.gets)
+	assertFileContent(t, destination, "version 2")
+}
+
+func TestDownloadFile_CorruptCacheEntry(t *testing.T) {
+	store := newFakeObjectStore()
+	store.put("mods/Mod.zip", []byte("contents"))
+	s3Client := makeTestCachedClient(t, store, 0)
+	downloadTestFile(t, s3Client, "mods/Mod.zip")
+
+	// Same size but different bytes so only the checksum catches it
+	blob, _ := s3Client.Cache.paths(cacheId("test-bucket", "mods/Mod.zip", store.etags["mods/Mod.zip"]))
+	require.NoError(t, os.WriteFile(blob, []byte("CONTENTS"), 0644))
+
+	result, destination := downloadTestFile(t, s3Client, "mods/Mod.zip")
+	assert.False(t, result.Cached)
+	assert.Equal(t, 2, store.gets)
+	assertFileContent(t, destination, "contents")
+}
+
+func TestDownloadCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	store := newFakeObjectStore()
+	for _, key := range []string{"mods/A.zip", "mods/B.zip", "mods/C.zip"} {
+		store.put(key, make([]byte, 100))
+	}
+	s3Client := makeTestCachedClient(t, store, 250)
+
+	downloadTestFile(t, s3Client, "mods/A.zip")
+	downloadTestFile(t, s3Client, "mods/B.zip")
+
+	// Using A makes B the least recently used entry. The times are set explicitly since file times can be too coarse
+	// to tell apart entries written in quick succession.
+	past := time.Now().Add(-time.Minute)
+	blobB, _ := s3Client.Cache.paths(cacheId("test-bucket", "mods/B.zip", store.etags["mods/B.zip"]))
+	require.NoError(t, os.Chtimes(blobB, past, past))
+	result, _ := downloadTestFile(t, s3Client, "mods/A.zip")
+	require.True(t, result.Cached)
+
+	downloadTestFile(t, s3Client, "mods/C.zip")
+
+	objects, err := s3Client.Cache.list()
+	require.NoError(t, err)
+	var keys []string
+	for _, object := range objects {
+		keys = append(keys, object.entry.Key)
+	}
+	assert.ElementsMatch(t, []string{"mods/A.zip", "mods/C.zip"}, keys)
+}
+
+func TestPruneCache(t *testing.T) {
+	store := newFakeObjectStore()
+	for _, key := range []string{"mods/Deleted.zip", "mods/Replaced.zip", "mods/Current.zip"} {
+		store.put(key, []byte(key))
+	}
+	s3Client := makeTestCachedClient(t, store, 0)
+	for _, key := range []string{"mods/Deleted.zip", "mods/Replaced.zip", "mods/Current.zip"} {
+		downloadTestFile(t, s3Client, key)
+	}
+
+	delete(store.objects, "mods/Deleted.zip")
+	store.put("mods/Replaced.zip", []byte("new contents"))
+
+	// A file left behind by a Job killed mid-write
+	orphan := filepath.Join(s3Client.Cache.Dir, "orphan.blob")
+	require.NoError(t, os.WriteFile(orphan, []byte("orphan"), 0644))
+	old := time.Now().Add(-2 * staleCacheFileAge)
+	require.NoError(t, os.Chtimes(orphan, old, old))
+
+	report, err := s3Client.PruneCache()
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Stale)
+	assert.Equal(t, 0, report.Evicted)
+	assert.Equal(t, int64(len("mods/Deleted.zip")+len("mods/Replaced.zip")+len("orphan")), report.Freed)
+	assert.Equal(t, int64(len("mods/Current.zip")), report.Size)
+
+	_, err = os.Stat(orphan)
+	assert.True(t, os.IsNotExist(err))
+
+	result, _ := downloadTestFile(t, s3Client, "mods/Current.zip")
+	assert.True(t, result.Cached)
+}
+
+func TestPruneCache_Disabled(t *testing.T) {
+	s3Client := &S3Client{BucketName: "test-bucket", client: newFakeObjectStore()}
+	_, err := s3Client.PruneCache()
+	assert.ErrorContains(t, err, "DOWNLOAD_CACHE_DIR")
+}
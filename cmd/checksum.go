@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"hash"
+	"hash/crc32"
+	"io"
+	"strings"
+)
+
+const (
+	ChecksumSHA256 = "SHA256"
+	ChecksumCRC32C = "CRC32C"
+	ChecksumETag   = "ETag"
+)
+
+// ChecksumMismatchError is returned when the bytes written to disk don't match the checksum S3 holds for the object.
+type ChecksumMismatchError struct {
+	Key       string
+	Algorithm string
+	Expected  string
+	Actual    string
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("%s checksum mismatch for %s: expected %s got %s", e.Algorithm, e.Key, e.Expected, e.Actual)
+}
+
+// expectedChecksum Picks the strongest checksum S3 returned for an object which can be verified against the whole
+// file. Composite checksums (multipart uploads) are skipped since they are a checksum of the part checksums. The ETag
+// is only an MD5 of the object for single part uploads which aren't KMS or SSE-C encrypted. Returns an empty algorithm
+// when nothing can be verified.
+func expectedChecksum(head *s3.HeadObjectOutput) (string, string) {
+	composite := head.ChecksumType == types.ChecksumTypeComposite
+
+	if sum := aws.ToString(head.ChecksumSHA256); sum != "" && !composite && !strings.Contains(sum, "-") {
+		return ChecksumSHA256, sum
+	}
+
+	if sum := aws.ToString(head.ChecksumCRC32C); sum != "" && !composite && !strings.Contains(sum, "-") {
+		return ChecksumCRC32C, sum
+	}
+
+	etag := strings.Trim(aws.ToString(head.ETag), `"`)
+	encrypted := head.ServerSideEncryption == types.ServerSideEncryptionAwsKms ||
+		head.ServerSideEncryption == types.ServerSideEncryptionAwsKmsDsse ||
+		head.SSECustomerAlgorithm != nil
+	if etag != "" && !strings.Contains(etag, "-") && !encrypted {
+		return ChecksumETag, etag
+	}
+
+	return "", ""
+}
+
+// verifyChecksum Hashes the contents of r and compares it against the checksum S3 holds for the object. The hex
+// encoded SHA-256 of the contents is always returned so callers have a digest to record even when S3 offered nothing
+// to verify against. The returned algorithm is the one the contents were verified with or empty when unverified.
+func verifyChecksum(key string, r io.Reader, head *s3.HeadObjectOutput) (string, string, error) {
+	algorithm, expected := expectedChecksum(head)
+
+	sha := sha256.New()
+	var verifier hash.Hash
+	switch algorithm {
+	case ChecksumCRC32C:
+		verifier = crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	case ChecksumETag:
+		verifier = md5.New()
+	}
+
+	writer := io.Writer(sha)
+	if verifier != nil {
+		writer = io.MultiWriter(sha, verifier)
+	}
+
+	if _, err := io.Copy(writer, r); err != nil {
+		return "", "", err
+	}
+
+	var actual string
+	switch algorithm {
+	case ChecksumSHA256:
+		actual = base64.StdEncoding.EncodeToString(sha.Sum(nil))
+	case ChecksumCRC32C:
+		actual = base64.StdEncoding.EncodeToString(verifier.Sum(nil))
+	case ChecksumETag:
+		actual = hex.EncodeToString(verifier.Sum(nil))
+	}
+
+	if actual != expected {
+		return "", "", &ChecksumMismatchError{Key: key, Algorithm: algorithm, Expected: expected, Actual: actual}
+	}
+
+	return hex.EncodeToString(sha.Sum(nil)), algorithm, nil
+}
@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"hash/crc32"
+	"strings"
+	"testing"
+)
+
+func TestExpectedChecksum(t *testing.T) {
+	tests := []struct {
+		name          string
+		head          *s3.HeadObjectOutput
+		wantAlgorithm string
+		wantChecksum  string
+	}{
+		{
+			name: "prefers sha256",
+			head: &s3.HeadObjectOutput{
+				ChecksumSHA256: aws.String("sha"),
+				ChecksumCRC32C: aws.String("crc"),
+				ETag:           aws.String(`"etag"`),
+			},
+			wantAlgorithm: ChecksumSHA256,
+			wantChecksum:  "sha",
+		},
+		{
+			name: "falls back to crc32c",
+			head: &s3.HeadObjectOutput{
+				ChecksumCRC32C: aws.String("crc"),
+				ETag:           aws.String(`"etag"`),
+			},
+			wantAlgorithm: ChecksumCRC32C,
+			wantChecksum:  "crc",
+		},
+		{
+			name:          "falls back to etag",
+			head:          &s3.HeadObjectOutput{ETag: aws.String(`"etag"`)},
+			wantAlgorithm: ChecksumETag,
+			wantChecksum:  "etag",
+		},
+		{
+			name: "skips composite checksums and multipart etags",
+			head: &s3.HeadObjectOutput{
+				ChecksumSHA256: aws.String("sha-3"),
+				ChecksumType:   types.ChecksumTypeComposite,
+				ETag:           aws.String(`"etag-3"`),
+			},
+		},
+		{
+			name: "skips etag of kms encrypted objects",
+			head: &s3.HeadObjectOutput{
+				ETag:                 aws.String(`"etag"`),
+				ServerSideEncryption: types.ServerSideEncryptionAwsKms,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			algorithm, checksum := expectedChecksum(tt.head)
+			assert.Equal(t, tt.wantAlgorithm, algorithm)
+			assert.Equal(t, tt.wantChecksum, checksum)
+		})
+	}
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	content := "valheim world"
+	sha := sha256.Sum256([]byte(content))
+	md5Sum := md5.Sum([]byte(content))
+	crc := crc32.Checksum([]byte(content), crc32.MakeTable(crc32.Castagnoli))
+	crcBytes := []byte{byte(crc >> 24), byte(crc >> 16), byte(crc >> 8), byte(crc)}
+
+	tests := []struct {
+		name          string
+		head          *s3.HeadObjectOutput
+		wantAlgorithm string
+		wantErr       bool
+	}{
+		{
+			name:          "sha256 match",
+			head:          &s3.HeadObjectOutput{ChecksumSHA256: aws.String(base64.StdEncoding.EncodeToString(sha[:]))},
+			wantAlgorithm: ChecksumSHA256,
+		},
+		{
+			name:          "crc32c match",
+			head:          &s3.HeadObjectOutput{ChecksumCRC32C: aws.String(base64.StdEncoding.EncodeToString(crcBytes))},
+			wantAlgorithm: ChecksumCRC32C,
+		},
+		{
+			name:          "etag match",
+			head:          &s3.HeadObjectOutput{ETag: aws.String(`"` + hex.EncodeToString(md5Sum[:]) + `"`)},
+			wantAlgorithm: ChecksumETag,
+		},
+		{
+			name:          "etag mismatch",
+			head:          &s3.HeadObjectOutput{ETag: aws.String(`"a0c3a4b1b4e4ab5cb0b4a0a5bd7e8c9a"`)},
+			wantAlgorithm: ChecksumETag,
+			wantErr:       true,
+		},
+		{
+			name: "nothing to verify",
+			head: &s3.HeadObjectOutput{ETag: aws.String(`"abc-2"`)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			digest, algorithm, err := verifyChecksum("key", strings.NewReader(content), tt.head)
+			if tt.wantErr {
+				var mismatch *ChecksumMismatchError
+				require.ErrorAs(t, err, &mismatch)
+				assert.Equal(t, tt.wantAlgorithm, mismatch.Algorithm)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantAlgorithm, algorithm)
+			assert.Equal(t, hex.EncodeToString(sha[:]), digest)
+		})
+	}
+}
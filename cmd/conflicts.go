@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// FileCollision A file an archive would install which was already installed by another archive.
+type FileCollision struct {
+	Path  string // Relative to the install root
+	Owner string // The archive which installed the file
+}
+
+// PackageConflict Another archive which installed a different copy of the same package.
+type PackageConflict struct {
+	Archive string
+	Version string
+}
+
+// InstallConflictError is returned when installing an archive would overwrite files installed by other mods or another
+// version of the same mod is already installed. The install can be forced with the -force flag.
+type InstallConflictError struct {
+	Archive    string
+	Collisions []FileCollision
+	Packages   []PackageConflict
+}
+
+func (e *InstallConflictError) Error() string {
+	var reasons []string
+	for _, pkg := range e.Packages {
+		reasons = append(reasons, fmt.Sprintf("%s installs another version (%s) of the same mod", pkg.Archive, pkg.Version))
+	}
+
+	byOwner := map[string][]string{}
+	var owners []string
+	for _, collision := range e.Collisions {
+		if _, ok := byOwner[collision.Owner]; !ok {
+			owners = append(owners, collision.Owner)
+		}
+		byOwner[collision.Owner] = append(byOwner[collision.Owner], collision.Path)
+	}
+	for _, owner := range owners {
+		reasons = append(reasons, fmt.Sprintf("%d files are owned by %s: %s", len(byOwner[owner]), owner, strings.Join(byOwner[owner], ", ")))
+	}
+
+	return fmt.Sprintf("%s conflicts with installed mods: %s. Use -force to install it anyway", e.Archive, strings.Join(reasons, "; "))
+}
+
+// readInstalledManifests Reads the manifest of every archive installed into the destination.
+func readInstalledManifests(destination string) ([]*InstallManifest, error) {
+	paths, err := filepath.Glob(filepath.Join(destination, workDirName, manifestDirName, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	var manifests []*InstallManifest
+	for _, path := range paths {
+		manifest, err := readManifest(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, manifest)
+	}
+	return manifests, nil
+}
+
+// findConflicts Compares the files the archive would install against the manifests of every other installed archive.
+// Reinstalling the same archive isn't a conflict. Returns nil when there are no conflicts.
+func (a *Archive) findConflicts(entries []archiveEntry, paths []string, metadata *PackageMetadata) (*InstallConflictError, error) {
+	manifests, err := readInstalledManifests(a.Destination)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read installed mod manifests: %w", err)
+	}
+
+	archive := filepath.Base(a.ZipFilePath)
+	conflict := &InstallConflictError{Archive: archive}
+	owners := map[string]string{}
+	for _, manifest := range manifests {
+		// The files of disabled mods aren't on disk to be overwritten
+		if manifest.Archive == archive || manifest.Disabled {
+			continue
+		}
+
+		for _, file := range manifest.Files {
+			owners[filepath.Join(manifest.Root, filepath.FromSlash(file.Path))] = manifest.Archive
+		}
+
+		if metadata != nil && manifest.Package != "" && strings.EqualFold(manifest.Package, metadata.Name) {
+			conflict.Packages = append(conflict.Packages, PackageConflict{Archive: manifest.Archive, Version: manifest.Version})
+		}
+	}
+
+	root := a.installRoot()
+	for i, path := range paths {
+		if path == "" || entries[i].IsDir() {
+			continue
+		}
+
+		if owner, ok := owners[path]; ok {
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return nil, err
+			}
+			conflict.Collisions = append(conflict.Collisions, FileCollision{Path: filepath.ToSlash(rel), Owner: owner})
+		}
+	}
+
+	if len(conflict.Collisions) == 0 && len(conflict.Packages) == 0 {
+		return nil, nil
+	}
+	return conflict, nil
+}
+
+// releaseCollisions Removes the files a forced install took over from the manifests of the archives which previously
+// owned them so uninstalling those archives later doesn't remove files that now belong to another mod.
+func (a *Archive) releaseCollisions(conflict *InstallConflictError) {
+	released := map[string][]string{}
+	for _, collision := range conflict.Collisions {
+		released[collision.Owner] = append(released[collision.Owner], collision.Path)
+	}
+
+	root := a.installRoot()
+	for owner, files := range released {
+		path := manifestPath(a.Destination, owner)
+		manifest, err := readManifest(path)
+		if err != nil {
+			log.Errorf("failed to read manifest for %s: %v", owner, err)
+			continue
+		}
+
+		manifest.Files = slices.DeleteFunc(manifest.Files, func(file ManifestFile) bool {
+			rel, err := filepath.Rel(root, filepath.Join(manifest.Root, filepath.FromSlash(file.Path)))
+			return err == nil && slices.Contains(files, filepath.ToSlash(rel))
+		})
+
+		if err := writeManifest(path, manifest); err != nil {
+			log.Errorf("failed to release %d files from the manifest for %s: %v", len(files), owner, err)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// installTestEntries Creates an archive named name in destDir from entries and installs it.
+func installTestEntries(t *testing.T, destDir, name string, force bool, entries []testZipEntry) (*Archive, error) {
+	t.Helper()
+	zipPath := filepath.Join(destDir, name)
+	require.NoError(t, os.Rename(createTestZipEntries(t, entries), zipPath))
+
+	a := &Archive{ZipFilePath: zipPath, Destination: destDir, Force: force}
+	return a, a.UnzipFile()
+}
+
+func TestUnzipFile_FileCollision(t *testing.T) {
+	destDir := t.TempDir()
+	_, err := installTestEntries(t, destDir, "A.zip", false, []testZipEntry{
+		{name: "Shared.dll", content: "a"},
+		{name: "A.dll", content: "a"},
+	})
+	require.NoError(t, err)
+
+	_, err = installTestEntries(t, destDir, "B.zip", false, []testZipEntry{
+		{name: "B.dll", content: "b"},
+		{name: "Shared.dll", content: "b"},
+	})
+	var conflictErr *InstallConflictError
+	require.True(t, errors.As(err, &conflictErr), "UnzipFile() error = %v, want *InstallConflictError", err)
+	assert.Equal(t, "B.zip", conflictErr.Archive)
+	assert.Equal(t, []FileCollision{{Path: "Shared.dll", Owner: "A.zip"}}, conflictErr.Collisions)
+	assert.Empty(t, conflictErr.Packages)
+	assert.Contains(t, err.Error(), "1 files are owned by A.zip: Shared.dll")
+	assert.Contains(t, err.Error(), "-force")
+
+	// Nothing from the refused archive is installed
+	assertFileContent(t, filepath.Join(destDir, "Shared.dll"), "a")
+	_, err = os.Stat(filepath.Join(destDir, "B.dll"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(manifestPath(destDir, "B.zip"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestUnzipFile_PackageVersionConflict(t *testing.T) {
+	destDir := t.TempDir()
+	_, err := installTestEntries(t, destDir, "Mod-1.0.0.zip", false, []testZipEntry{
+		{name: "manifest.json", content: `{"name":"Mod","version_number":"1.0.0"}`},
+		{name: "Mod/Mod.dll", content: "v1"},
+	})
+	require.NoError(t, err)
+
+	manifest, err := readManifest(manifestPath(destDir, "Mod-1.0.0.zip"))
+	require.NoError(t, err)
+	assert.Equal(t, "Mod", manifest.Package)
+	assert.Equal(t, "1.0.0", manifest.Version)
+
+	_, err = installTestEntries(t, destDir, "Mod-1.1.0.zip", false, []testZipEntry{
+		{name: "manifest.json", content: `{"name":"Mod","version_number":"1.1.0"}`},
+		{name: "Mod/Mod.dll", content: "v2"},
+	})
+	var conflictErr *InstallConflictError
+	require.True(t, errors.As(err, &conflictErr), "UnzipFile() error = %v, want *InstallConflictError", err)
+	assert.Equal(t, []PackageConflict{{Archive: "Mod-1.0.0.zip", Version: "1.0.0"}}, conflictErr.Packages)
+	assert.ElementsMatch(t, []FileCollision{
+		{Path: "manifest.json", Owner: "Mod-1.0.0.zip"},
+		{Path: "Mod/Mod.dll", Owner: "Mod-1.0.0.zip"},
+	}, conflictErr.Collisions)
+	assertFileContent(t, filepath.Join(destDir, "Mod/Mod.dll"), "v1")
+}
+
+func TestUnzipFile_ReinstallIsNotAConflict(t *testing.T) {
+	destDir := t.TempDir()
+	entries := []testZipEntry{{name: "manifest.json", content: `{"name":"Mod","version_number":"1.0.0"}`}, {name: "Mod.dll", content: "dll"}}
+	_, err := installTestEntries(t, destDir, "Mod.zip", false, entries)
+	require.NoError(t, err)
+	_, err = installTestEntries(t, destDir, "Mod.zip", false, entries)
+	require.NoError(t, err)
+}
+
+func TestUnzipFile_ForceTakesOverCollidingFiles(t *testing.T) {
+	destDir := t.TempDir()
+	a, err := installTestEntries(t, destDir, "A.zip", false, []testZipEntry{
+		{name: "Shared.dll", content: "a"},
+		{name: "A.dll", content: "a"},
+	})
+	require.NoError(t, err)
+
+	_, err = installTestEntries(t, destDir, "B.zip", true, []testZipEntry{
+		{name: "Shared.dll", content: "b"},
+	})
+	require.NoError(t, err)
+	assertFileContent(t, filepath.Join(destDir, "Shared.dll"), "b")
+
+	// A no longer owns the file B took over so uninstalling A leaves it in place
+	manifest, err := readManifest(manifestPath(destDir, "A.zip"))
+	require.NoError(t, err)
+	assert.Equal(t, []ManifestFile{{Path: "A.dll", Size: 1, SHA256: mustHash(t, "a")}}, manifest.Files)
+
+	report, err := a.RemoveFilesFromZip()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"A.dll"}, report.Removed)
+	assertFileContent(t, filepath.Join(destDir, "Shared.dll"), "b")
+}
@@ -0,0 +1,332 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// defaultProvidedDependencies Packages which are part of the server image rather than installed by the file manager.
+var defaultProvidedDependencies = []string{"denikson-BepInExPack_Valheim"}
+
+// PackageDependency A Thunderstore dependency string of the form Author-Name-Version.
+type PackageDependency struct {
+	Author  string
+	Name    string
+	Version string // The minimum version required
+}
+
+// ParsePackageDependency Parses a dependency string like denikson-BepInExPack_Valheim-5.4.2202. Thunderstore doesn't
+// allow dashes in team or package names so the string always has exactly three parts.
+func ParsePackageDependency(dependency string) (PackageDependency, error) {
+	parts := strings.Split(strings.TrimSpace(dependency), "-")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return PackageDependency{}, fmt.Errorf("invalid dependency %q, expected Author-Name-Version", dependency)
+	}
+	return PackageDependency{Author: parts[0], Name: parts[1], Version: parts[2]}, nil
+}
+
+func (d PackageDependency) String() string {
+	return fmt.Sprintf("%s-%s-%s", d.Author, d.Name, d.Version)
+}
+
+// key Identifies the package regardless of its version.
+func (d PackageDependency) key() string {
+	return strings.ToLower(d.Author + "-" + d.Name)
+}
+
+// MissingDependenciesError is returned when a mod depends on packages which aren't installed and can't be installed.
+type MissingDependenciesError struct {
+	Package string
+	Missing []string // Each missing dependency along with why it couldn't be installed
+}
+
+func (e *MissingDependenciesError) Error() string {
+	return fmt.Sprintf("%s is missing %d dependencies: %s", e.Package, len(e.Missing), strings.Join(e.Missing, ", "))
+}
+
+// ResolvedPackage A dependency which was downloaded and needs installing.
+type ResolvedPackage struct {
+	Dependency PackageDependency
+	Prefix     string // The S3 key the package was downloaded from
+	Archive    *Archive
+	Metadata   *PackageMetadata
+	existing   bool // The archive already had an install manifest i.e. it was installed but disabled
+}
+
+// DependencyResolver Works out which of a mod's dependencies are missing from the plugins directory and downloads them
+// from the dependency prefix in S3 so they can be installed before the mod.
+type DependencyResolver struct {
+	Prefix      string   // The S3 prefix holding Author-Name-Version.zip packages. Missing dependencies fail the install when empty
+	Provided    []string // Author-Name of packages which are always available i.e. BepInEx itself
+	Destination string   // The plugins directory
+	Layout      string
+	s3Client    *S3Client
+}
+
+// MakeDependencyResolver Creates a resolver for mods installed by the file manager. Dependencies are downloaded from the
+// DEPENDENCY_PREFIX environment variable and PROVIDED_DEPENDENCIES lists packages already part of the server image.
+func MakeDependencyResolver(s3Client *S3Client, fileManager *FileManager) *DependencyResolver {
+	provided := getEnvList("PROVIDED_DEPENDENCIES")
+	if len(provided) == 0 {
+		provided = defaultProvidedDependencies
+	}
+
+	return &DependencyResolver{
+		Prefix:      os.Getenv("DEPENDENCY_PREFIX"),
+		Provided:    provided,
+		Destination: fileManager.Destination,
+		Layout:      fileManager.ArchiveHandler.Layout,
+		s3Client:    s3Client,
+	}
+}
+
+// Resolve Returns the dependencies of the package which need installing, downloading each one and its own
+// dependencies. The packages are ordered so every package comes after the packages it depends on. If any dependency
+// can't be found a MissingDependenciesError listing all of them is returned and anything downloaded is removed.
+func (r *DependencyResolver) Resolve(metadata *PackageMetadata) ([]*ResolvedPackage, error) {
+	installed := r.installedPackages()
+
+	var order []*ResolvedPackage
+	var missing []string
+	visited := map[string]bool{}
+
+	var visit func(dependencies []string)
+	visit = func(dependencies []string) {
+		for _, raw := range dependencies {
+			dependency, err := ParsePackageDependency(raw)
+			if err != nil {
+				missing = append(missing, err.Error())
+				continue
+			}
+
+			if visited[dependency.key()] {
+				continue
+			}
+			visited[dependency.key()] = true
+
+			if pkg, ok := satisfies(installed, dependency); ok {
+				log.Infof("dependency %s is satisfied by installed version %q", dependency, pkg.Version)
+				continue
+			}
+
+			if r.Prefix == "" {
+				missing = append(missing, fmt.Sprintf("%s (not installed)", dependency))
+				continue
+			}
+
+			resolved, err := r.download(dependency)
+			if err != nil {
+				log.Errorf("failed to download dependency %s: %v", dependency, err)
+				missing = append(missing, fmt.Sprintf("%s (%v)", dependency, err))
+				continue
+			}
+
+			// Dependencies of the dependency are visited first so they're installed before it
+			if resolved.Metadata != nil {
+				visit(resolved.Metadata.Dependencies)
+			}
+			order = append(order, resolved)
+		}
+	}
+	visit(metadata.Dependencies)
+
+	if len(missing) > 0 {
+		for _, resolved := range order {
+			os.Remove(resolved.Archive.ZipFilePath)
+		}
+		return nil, &MissingDependenciesError{Package: metadata.Name, Missing: missing}
+	}
+	return order, nil
+}
+
+// Install Installs the resolved packages in order. The mod which needs them won't be installed if any of them fails so
+// the packages installed before the failure are rolled back and the archives of the rest are removed.
+func (r *DependencyResolver) Install(packages []*ResolvedPackage) error {
+	for _, resolved := range packages {
+		_, err := os.Stat(manifestPath(resolved.Archive.Destination, resolved.Archive.ZipFilePath))
+		resolved.existing = err == nil
+	}
+
+	for i, resolved := range packages {
+		log.Infof("installing dependency %s", resolved.Dependency)
+		if err := resolved.Archive.UnzipFile(); err != nil {
+			r.Rollback(packages[:i])
+			for _, remaining := range packages[i:] {
+				if !remaining.existing {
+					os.Remove(remaining.Archive.ZipFilePath)
+				}
+			}
+			return fmt.Errorf("failed to install dependency %s: %w", resolved.Dependency, err)
+		}
+	}
+	return nil
+}
+
+// Rollback Uninstalls the installed packages, along with their archives, in reverse order. It's used when the mod which
+// depends on them fails to install so its dependencies aren't left behind in the plugins directory. Packages which were
+// already installed but disabled before Install are left in place. Every package is attempted and the errors of the
+// ones which failed are returned.
+func (r *DependencyResolver) Rollback(packages []*ResolvedPackage) error {
+	var errs []error
+	for _, resolved := range slices.Backward(packages) {
+		if resolved.existing {
+			continue
+		}
+
+		log.Infof("removing dependency %s", resolved.Dependency)
+		if _, err := resolved.Archive.RemoveFilesFromZip(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove dependency %s: %w", resolved.Dependency, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// download Downloads the dependency's package into the plugins directory and reads its metadata.
+func (r *DependencyResolver) download(dependency PackageDependency) (*ResolvedPackage, error) {
+	fileName := dependency.String() + ".zip"
+	fileManager := &FileManager{
+		Op:                  WRITE,
+		Prefix:              path.Join(r.Prefix, fileName),
+		FileName:            fileName,
+		FileDestinationPath: filepath.Join(r.Destination, fileName),
+	}
+
+	if _, err := r.s3Client.DownloadFile(fileManager); err != nil {
+		return nil, err
+	}
+
+	archive := &Archive{ZipFilePath: fileManager.FileDestinationPath, Destination: r.Destination, Layout: r.Layout}
+	metadata, err := archive.ReadPackageMetadata()
+	if err != nil {
+		os.Remove(archive.ZipFilePath)
+		return nil, err
+	}
+
+	return &ResolvedPackage{Dependency: dependency, Prefix: fileManager.Prefix, Archive: archive, Metadata: metadata}, nil
+}
+
+// installedPackage A package found in the plugins directory. The author and version are empty when they aren't known.
+type installedPackage struct {
+	Author  string
+	Version string
+}
+
+// installedPackages Returns the packages installed in the plugins directory keyed by their lower case name. Packages are
+// found from the install manifests of the archives the file manager installed, skipping disabled ones whose files aren't
+// in place, and from the manifest.json Thunderstore installs put in each package's plugin folder (or the plugins
+// directory itself for flat installs) for packages installed some other way. Archives on their own aren't trusted since
+// one is left behind when its install fails.
+func (r *DependencyResolver) installedPackages() map[string][]installedPackage {
+	installed := map[string][]installedPackage{}
+	add := func(author, name, version string) {
+		installed[strings.ToLower(name)] = append(installed[strings.ToLower(name)], installedPackage{Author: author, Version: version})
+	}
+
+	for _, provided := range r.Provided {
+		author, name, found := strings.Cut(provided, "-")
+		if !found {
+			author, name = "", provided
+		}
+		add(author, name, "")
+	}
+
+	manifests, err := readInstalledManifests(r.Destination)
+	if err != nil {
+		log.Warnf("failed to read install manifests in %s: %v", r.Destination, err)
+	}
+	for _, manifest := range manifests {
+		if manifest.Disabled {
+			continue
+		}
+
+		// Thunderstore names its downloads Author-Name-Version which is the only place the author is recorded
+		archive, err := ParsePackageDependency(packageName(manifest.Archive))
+		switch {
+		case manifest.Package != "" && err == nil:
+			add(archive.Author, manifest.Package, manifest.Version)
+		case manifest.Package != "":
+			add("", manifest.Package, manifest.Version)
+		case err == nil:
+			add(archive.Author, archive.Name, archive.Version)
+		}
+	}
+
+	entries, err := os.ReadDir(r.Destination)
+	if err != nil {
+		log.Warnf("failed to list installed packages in %s: %v", r.Destination, err)
+		return installed
+	}
+
+	dirs := []string{""}
+	for _, entry := range entries {
+		if entry.IsDir() && entry.Name() != workDirName {
+			dirs = append(dirs, entry.Name())
+		}
+	}
+
+	for _, dir := range dirs {
+		data, err := os.ReadFile(filepath.Join(r.Destination, dir, "manifest.json"))
+		if err != nil {
+			continue
+		}
+
+		var manifest PackageManifest
+		if err := json.Unmarshal(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")), &manifest); err != nil || manifest.Name == "" {
+			continue
+		}
+
+		// Mod managers name the folder Author-Name, otherwise the author isn't known
+		var author string
+		if before, _, found := strings.Cut(dir, "-"); found {
+			author = before
+		}
+		add(author, manifest.Name, manifest.VersionNumber)
+	}
+	return installed
+}
+
+// satisfies Returns the installed package which satisfies the dependency. A package with an unknown author or version
+// is trusted to satisfy it since there's nothing better to go on.
+func satisfies(installed map[string][]installedPackage, dependency PackageDependency) (installedPackage, bool) {
+	for _, pkg := range installed[strings.ToLower(dependency.Name)] {
+		if (pkg.Author == "" || strings.EqualFold(pkg.Author, dependency.Author)) && compareVersions(pkg.Version, dependency.Version) >= 0 {
+			return pkg, true
+		}
+	}
+	return installedPackage{}, false
+}
+
+// compareVersions Compares two dotted version numbers returning -1, 0 or 1. Empty versions are equal to any version.
+func compareVersions(a, b string) int {
+	if a == "" || b == "" {
+		return 0
+	}
+
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < max(len(as), len(bs)); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,229 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParsePackageDependency(t *testing.T) {
+	tests := []struct {
+		dependency string
+		want       PackageDependency
+		wantErr    bool
+	}{
+		{dependency: "denikson-BepInExPack_Valheim-5.4.2202", want: PackageDependency{Author: "denikson", Name: "BepInExPack_Valheim", Version: "5.4.2202"}},
+		{dependency: " ValheimModding-Jotunn-2.20.0 ", want: PackageDependency{Author: "ValheimModding", Name: "Jotunn", Version: "2.20.0"}},
+		{dependency: "Jotunn-2.20.0", wantErr: true},
+		{dependency: "a-b-c-d", wantErr: true},
+		{dependency: "a--1.0.0", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dependency, func(t *testing.T) {
+			got, err := ParsePackageDependency(tt.dependency)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{a: "1.0.0", b: "1.0.0", want: 0},
+		{a: "1.10.0", b: "1.9.0", want: 1},
+		{a: "1.0", b: "1.0.1", want: -1},
+		{a: "5.4.2202", b: "5.4.2105", want: 1},
+		{a: "", b: "1.0.0", want: 0},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, compareVersions(tt.a, tt.b), "compareVersions(%q, %q)", tt.a, tt.b)
+	}
+}
+
+// putTestPackage Stores a Thunderstore package in the fake object store under deps/ with the given dependencies.
+func putTestPackage(t *testing.T, store *fakeObjectStore, author, name, version string, dependencies ...string) {
+	t.Helper()
+	manifest, err := json.Marshal(PackageManifest{Name: name, VersionNumber: version, Dependencies: dependencies})
+	require.NoError(t, err)
+
+	zipPath := createTestZipEntries(t, []testZipEntry{
+		{name: "manifest.json", content: string(manifest)},
+		{name: fmt.Sprintf("plugins/%s.dll", name), content: name},
+	})
+	defer os.Remove(zipPath)
+
+	data, err := os.ReadFile(zipPath)
+	require.NoError(t, err)
+	store.put(fmt.Sprintf("deps/%s-%s-%s.zip", author, name, version), data)
+}
+
+func makeTestResolver(t *testing.T, store *fakeObjectStore, prefix string) *DependencyResolver {
+	pluginsDir := filepath.Join(t.TempDir(), "plugins")
+	require.NoError(t, os.MkdirAll(pluginsDir, 0755))
+
+	// A package installed by a mod manager, a flat install with only its archive name to go on, a disabled mod and an
+	// archive left behind by a failed install
+	createTestFiles(t, map[string]string{
+		"Installed-Library/manifest.json": `{"name":"Library","version_number":"2.0.0"}`,
+		"Other-Tool-1.2.0.zip":            "zip",
+		"Author-Disabled-1.0.0.zip":       "zip",
+		"Author-Failed-1.0.0.zip":         "zip",
+	}, pluginsDir)
+	require.NoError(t, writeManifest(manifestPath(pluginsDir, "Other-Tool-1.2.0.zip"), &InstallManifest{Archive: "Other-Tool-1.2.0.zip"}))
+	require.NoError(t, writeManifest(manifestPath(pluginsDir, "Author-Disabled-1.0.0.zip"), &InstallManifest{Archive: "Author-Disabled-1.0.0.zip", Package: "Disabled", Version: "1.0.0", Disabled: true}))
+
+	return &DependencyResolver{
+		Prefix:      prefix,
+		Provided:    defaultProvidedDependencies,
+		Destination: pluginsDir,
+		Layout:      LayoutThunderstore,
+		s3Client:    &S3Client{BucketName: "test-bucket", Retry: makeTestRetryPolicy(1, nil), client: store},
+	}
+}
+
+func TestDependencyResolver_Resolve(t *testing.T) {
+	store := newFakeObjectStore()
+	putTestPackage(t, store, "Author", "Framework", "1.0.0", "Author-Core-1.0.0", "denikson-BepInExPack_Valheim-5.4.2202")
+	putTestPackage(t, store, "Author", "Core", "1.0.0", "Installed-Library-1.5.0")
+	resolver := makeTestResolver(t, store, "deps")
+
+	metadata := &PackageMetadata{PackageManifest: PackageManifest{
+		Name: "Mod",
+		Dependencies: []string{
+			"denikson-BepInExPack_Valheim-5.4.2202",
+			"Author-Framework-1.0.0",
+			"Installed-Library-1.5.0",
+			"Other-Tool-1.0.0",
+			"Author-Core-1.0.0",
+		},
+	}}
+
+	resolved, err := resolver.Resolve(metadata)
+	require.NoError(t, err)
+
+	var order []string
+	for _, pkg := range resolved {
+		order = append(order, pkg.Dependency.String())
+	}
+	assert.Equal(t, []string{"Author-Core-1.0.0", "Author-Framework-1.0.0"}, order)
+	assert.Equal(t, "deps/Author-Core-1.0.0.zip", resolved[0].Prefix)
+	assert.Equal(t, "Core", resolved[0].Metadata.Name)
+
+	require.NoError(t, resolver.Install(resolved))
+	assertFileContent(t, filepath.Join(resolver.Destination, "Author-Core-1.0.0", "Core.dll"), "Core")
+	assertFileContent(t, filepath.Join(resolver.Destination, "Author-Framework-1.0.0", "Framework.dll"), "Framework")
+
+	// Once installed the same dependencies are satisfied without downloading anything
+	resolved, err = resolver.Resolve(metadata)
+	require.NoError(t, err)
+	assert.Empty(t, resolved)
+}
+
+func TestDependencyResolver_Rollback(t *testing.T) {
+	tests := []struct {
+		name string
+		// conflict Makes Framework collide with another mod's files so installing it fails, otherwise both install
+		// and the mod which needs them fails instead
+		conflict bool
+	}{
+		{name: "mod fails to install"},
+		{name: "dependency fails to install", conflict: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeObjectStore()
+			putTestPackage(t, store, "Author", "Framework", "1.0.0", "Author-Core-1.0.0")
+			putTestPackage(t, store, "Author", "Core", "1.0.0")
+			resolver := makeTestResolver(t, store, "deps")
+			if tt.conflict {
+				require.NoError(t, writeManifest(manifestPath(resolver.Destination, "Other.zip"), &InstallManifest{
+					Archive: "Other.zip",
+					Root:    resolver.Destination,
+					Files:   []ManifestFile{{Path: "Author-Framework-1.0.0/Framework.dll"}},
+				}))
+			}
+
+			resolved, err := resolver.Resolve(&PackageMetadata{PackageManifest: PackageManifest{
+				Name:         "Mod",
+				Dependencies: []string{"Author-Framework-1.0.0"},
+			}})
+			require.NoError(t, err)
+			require.Len(t, resolved, 2)
+
+			if tt.conflict {
+				require.Error(t, resolver.Install(resolved))
+			} else {
+				require.NoError(t, resolver.Install(resolved))
+				require.NoError(t, resolver.Rollback(resolved))
+			}
+
+			for _, name := range []string{"Author-Core-1.0.0", "Author-Framework-1.0.0"} {
+				assert.NoFileExists(t, filepath.Join(resolver.Destination, name+".zip"))
+				assert.NoFileExists(t, manifestPath(resolver.Destination, name+".zip"))
+				assert.NoDirExists(t, filepath.Join(resolver.Destination, name))
+			}
+
+			// Packages which were already installed are left alone
+			assertFileContent(t, filepath.Join(resolver.Destination, "Installed-Library/manifest.json"), `{"name":"Library","version_number":"2.0.0"}`)
+			assert.FileExists(t, manifestPath(resolver.Destination, "Other-Tool-1.2.0.zip"))
+		})
+	}
+}
+
+func TestDependencyResolver_Missing(t *testing.T) {
+	tests := []struct {
+		name        string
+		prefix      string
+		wantMissing int
+	}{
+		{name: "no dependency prefix", prefix: "", wantMissing: 5},
+		{name: "not in s3", prefix: "deps", wantMissing: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeObjectStore()
+			putTestPackage(t, store, "Author", "Framework", "1.0.0")
+			resolver := makeTestResolver(t, store, tt.prefix)
+
+			_, err := resolver.Resolve(&PackageMetadata{PackageManifest: PackageManifest{
+				Name: "Mod",
+				Dependencies: []string{
+					"Author-Framework-1.0.0",
+					"Installed-Library-3.0.0", // Installed but too old
+					"Author-Unknown-1.0.0",
+					"Author-Disabled-1.0.0",
+					"Author-Failed-1.0.0",
+				},
+			}})
+
+			var missingErr *MissingDependenciesError
+			require.True(t, errors.As(err, &missingErr), "Resolve() error = %v, want *MissingDependenciesError", err)
+			assert.Equal(t, "Mod", missingErr.Package)
+			assert.Len(t, missingErr.Missing, tt.wantMissing)
+			assert.Contains(t, err.Error(), "Author-Unknown-1.0.0")
+			assert.Contains(t, err.Error(), "Installed-Library-3.0.0")
+			assert.Contains(t, err.Error(), "Author-Disabled-1.0.0")
+			assert.Contains(t, err.Error(), "Author-Failed-1.0.0")
+
+			// Nothing downloaded for a failed resolution is left behind
+			_, err = os.Stat(filepath.Join(resolver.Destination, "Author-Framework-1.0.0.zip"))
+			assert.True(t, os.IsNotExist(err))
+		})
+	}
+}
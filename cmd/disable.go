@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// disabledDirName The directory alongside the plugins directory where the files of disabled mods are kept. BepInEx
+// only loads from its plugins, patchers, config and core directories so nothing in here is loaded.
+const disabledDirName = "plugins_disabled"
+
+// fileMove A file moved between the install root and the disabled directory.
+type fileMove struct {
+	from string
+	to   string
+}
+
+// disabledDir Returns the directory the files of this archive are moved to while it's disabled.
+func (a *Archive) disabledDir() string {
+	return filepath.Join(filepath.Dir(filepath.Clean(a.Destination)), disabledDirName, filepath.Base(a.ZipFilePath))
+}
+
+// readInstallManifest Reads the install manifest for the archive which disabling and enabling depend on to know which
+// files belong to the mod.
+func (a *Archive) readInstallManifest() (string, *InstallManifest, error) {
+	path := manifestPath(a.Destination, a.ZipFilePath)
+	manifest, err := readManifest(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return path, nil, fmt.Errorf("%s has no install manifest, reinstall it to disable or enable it: %w", a.ZipFilePath, err)
+	}
+	return path, manifest, err
+}
+
+// Disable Moves every file the archive installed into the disabled directory so the mod isn't loaded but can be
+// enabled again later without downloading it. The archive itself is left where it is.
+func (a *Archive) Disable() error {
+	path, manifest, err := a.readInstallManifest()
+	if err != nil {
+		return err
+	}
+
+	if manifest.Disabled {
+		log.Infof("%s is already disabled", a.ZipFilePath)
+		return nil
+	}
+
+	disabledDir := a.disabledDir()
+	var moves []fileMove
+	for _, file := range manifest.Files {
+		from := filepath.Join(manifest.Root, filepath.FromSlash(file.Path))
+		if _, err := os.Lstat(from); os.IsNotExist(err) {
+			log.Warnf("file %s has already been removed, skipping", from)
+			continue
+		}
+		moves = append(moves, fileMove{from: from, to: filepath.Join(disabledDir, filepath.FromSlash(file.Path))})
+	}
+
+	if err := moveFiles(moves); err != nil {
+		return fmt.Errorf("failed to disable %s: %w", a.ZipFilePath, err)
+	}
+	removeEmptyDirs(manifest.Root, manifest.Dirs)
+
+	manifest.Disabled = true
+	if err := writeManifest(path, manifest); err != nil {
+		return revertMoves(moves, fmt.Errorf("failed to update install manifest: %w", err))
+	}
+
+	log.Infof("disabled %s, moved %d files to %s", a.ZipFilePath, len(moves), disabledDir)
+	return nil
+}
+
+// Enable Moves the files of a disabled archive back to where they were installed. Enabling is refused if another file
+// has since been installed in place of one of the mod's files unless Force is set.
+func (a *Archive) Enable() error {
+	path, manifest, err := a.readInstallManifest()
+	if err != nil {
+		return err
+	}
+
+	if !manifest.Disabled {
+		log.Infof("%s is already enabled", a.ZipFilePath)
+		return nil
+	}
+
+	disabledDir := a.disabledDir()
+	var moves []fileMove
+	var existing []string
+	for _, file := range manifest.Files {
+		from := filepath.Join(disabledDir, filepath.FromSlash(file.Path))
+		if _, err := os.Lstat(from); os.IsNotExist(err) {
+			log.Warnf("disabled file %s is missing, skipping", from)
+			continue
+		}
+
+		to := filepath.Join(manifest.Root, filepath.FromSlash(file.Path))
+		if _, err := os.Lstat(to); err == nil {
+			existing = append(existing, file.Path)
+		}
+		moves = append(moves, fileMove{from: from, to: to})
+	}
+
+	if len(existing) > 0 {
+		if !a.Force {
+			return fmt.Errorf("cannot enable %s, files have been installed in place of: %s. Use -force to enable it anyway", a.ZipFilePath, strings.Join(existing, ", "))
+		}
+		log.Warnf("overwriting %d files installed in place of %s since force is set: %v", len(existing), a.ZipFilePath, existing)
+	}
+
+	if err := moveFiles(moves); err != nil {
+		return fmt.Errorf("failed to enable %s: %w", a.ZipFilePath, err)
+	}
+
+	manifest.Disabled = false
+	if err := writeManifest(path, manifest); err != nil {
+		return revertMoves(moves, fmt.Errorf("failed to update install manifest: %w", err))
+	}
+
+	removeDisabledDir(disabledDir)
+	log.Infof("enabled %s, restored %d files", a.ZipFilePath, len(moves))
+	return nil
+}
+
+// moveFiles Moves each file creating any missing parent directories. If a move fails the files already moved are moved
+// back so a mod is never left half disabled.
+func moveFiles(moves []fileMove) error {
+	for i, move := range moves {
+		err := os.MkdirAll(filepath.Dir(move.to), 0755)
+		if err == nil {
+			err = os.Rename(move.from, move.to)
+		}
+
+		if err != nil {
+			return revertMoves(moves[:i], err)
+		}
+	}
+	return nil
+}
+
+// revertMoves Moves files back to where they came from after err.
+func revertMoves(moves []fileMove, err error) error {
+	var errs []error
+	for _, move := range slices.Backward(moves) {
+		if mkdirErr := os.MkdirAll(filepath.Dir(move.from), 0755); mkdirErr != nil {
+			errs = append(errs, mkdirErr)
+			continue
+		}
+		if renameErr := os.Rename(move.to, move.from); renameErr != nil {
+			errs = append(errs, renameErr)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%w (failed to move files back: %v)", err, errors.Join(errs...))
+	}
+	return err
+}
+
+// removeDisabledDir Removes the disabled directory of an archive along with the disabled directory itself when no other
+// mods are disabled.
+func removeDisabledDir(disabledDir string) {
+	if err := os.RemoveAll(disabledDir); err != nil {
+		log.Warnf("failed to remove %s: %v", disabledDir, err)
+	}
+	os.Remove(filepath.Dir(disabledDir))
+}
@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// makeTestPluginsDir Creates a plugins directory inside a temporary BepInEx directory so the disabled directory is
+// created alongside it.
+func makeTestPluginsDir(t *testing.T) string {
+	pluginsDir := filepath.Join(t.TempDir(), "plugins")
+	require.NoError(t, os.MkdirAll(pluginsDir, 0755))
+	return pluginsDir
+}
+
+func TestDisableEnable(t *testing.T) {
+	pluginsDir := makeTestPluginsDir(t)
+	a, err := installTestEntries(t, pluginsDir, "Mod.zip", false, []testZipEntry{
+		{name: "Mod/Mod.dll", content: "dll"},
+		{name: "Mod/Assets/a.png", content: "png"},
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, a.Disable())
+	disabledDir := filepath.Join(filepath.Dir(pluginsDir), disabledDirName, "Mod.zip")
+	assertFileContent(t, filepath.Join(disabledDir, "Mod", "Mod.dll"), "dll")
+	assertFileContent(t, filepath.Join(disabledDir, "Mod", "Assets", "a.png"), "png")
+
+	// The directories the mod created are removed but the archive is kept to enable it again
+	_, err = os.Stat(filepath.Join(pluginsDir, "Mod"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(a.ZipFilePath)
+	assert.NoError(t, err)
+
+	manifest, err := readManifest(manifestPath(pluginsDir, "Mod.zip"))
+	require.NoError(t, err)
+	assert.True(t, manifest.Disabled)
+
+	// Disabling twice is a no-op
+	require.NoError(t, a.Disable())
+
+	require.NoError(t, a.Enable())
+	assertFileContent(t, filepath.Join(pluginsDir, "Mod", "Mod.dll"), "dll")
+	assertFileContent(t, filepath.Join(pluginsDir, "Mod", "Assets", "a.png"), "png")
+	_, err = os.Stat(filepath.Join(filepath.Dir(pluginsDir), disabledDirName))
+	assert.True(t, os.IsNotExist(err), "the disabled directory should be removed once no mods are disabled")
+
+	manifest, err = readManifest(manifestPath(pluginsDir, "Mod.zip"))
+	require.NoError(t, err)
+	assert.False(t, manifest.Disabled)
+}
+
+func TestDisable_ThunderstoreLayout(t *testing.T) {
+	pluginsDir := makeTestPluginsDir(t)
+	zipPath := filepath.Join(pluginsDir, "Author-Mod-1.0.0.zip")
+	require.NoError(t, os.Rename(createTestZipEntries(t, []testZipEntry{
+		{name: "manifest.json", content: `{"name":"Mod","version_number":"1.0.0"}`},
+		{name: "plugins/Mod.dll", content: "dll"},
+		{name: "config/Mod.cfg", content: "cfg"},
+	}), zipPath))
+
+	a := &Archive{ZipFilePath: zipPath, Destination: pluginsDir, Layout: LayoutThunderstore}
+	require.NoError(t, a.UnzipFile())
+
+	require.NoError(t, a.Disable())
+	bepInExDir := filepath.Dir(pluginsDir)
+	disabledDir := filepath.Join(bepInExDir, disabledDirName, "Author-Mod-1.0.0.zip")
+	assertFileContent(t, filepath.Join(disabledDir, "plugins", "Author-Mod-1.0.0", "Mod.dll"), "dll")
+	assertFileContent(t, filepath.Join(disabledDir, "config", "Mod.cfg"), "cfg")
+	_, err := os.Stat(filepath.Join(bepInExDir, "config", "Mod.cfg"))
+	assert.True(t, os.IsNotExist(err))
+
+	require.NoError(t, a.Enable())
+	assertFileContent(t, filepath.Join(pluginsDir, "Author-Mod-1.0.0", "Mod.dll"), "dll")
+	assertFileContent(t, filepath.Join(bepInExDir, "config", "Mod.cfg"), "cfg")
+}
+
+func TestEnable_FileInstalledInPlace(t *testing.T) {
+	pluginsDir := makeTestPluginsDir(t)
+	a, err := installTestEntries(t, pluginsDir, "A.zip", false, []testZipEntry{{name: "Shared.dll", content: "a"}})
+	require.NoError(t, err)
+	require.NoError(t, a.Disable())
+
+	// Another mod can install the same file while A is disabled since A's files aren't on disk
+	_, err = installTestEntries(t, pluginsDir, "B.zip", false, []testZipEntry{{name: "Shared.dll", content: "b"}})
+	require.NoError(t, err)
+
+	err = a.Enable()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Shared.dll")
+	assert.Contains(t, err.Error(), "-force")
+	assertFileContent(t, filepath.Join(pluginsDir, "Shared.dll"), "b")
+
+	a.Force = true
+	require.NoError(t, a.Enable())
+	assertFileContent(t, filepath.Join(pluginsDir, "Shared.dll"), "a")
+}
+
+func TestRemoveFilesFromZip_Disabled(t *testing.T) {
+	pluginsDir := makeTestPluginsDir(t)
+	a, err := installTestEntries(t, pluginsDir, "Mod.zip", false, []testZipEntry{{name: "Mod.dll", content: "dll"}})
+	require.NoError(t, err)
+	require.NoError(t, a.Disable())
+
+	result, err := a.RemoveFilesFromZip()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Mod.dll"}, result.Removed)
+
+	_, err = os.Stat(filepath.Join(filepath.Dir(pluginsDir), disabledDirName))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(manifestPath(pluginsDir, "Mod.zip"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestDisable_NoManifest(t *testing.T) {
+	pluginsDir := makeTestPluginsDir(t)
+	a := &Archive{ZipFilePath: filepath.Join(pluginsDir, "Mod.zip"), Destination: pluginsDir}
+
+	err := a.Disable()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no install manifest")
+	assert.Error(t, a.Enable())
+}
@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestMakeS3Endpoint(t *testing.T) {
+	t.Setenv("S3_ENDPOINT_URL", "")
+	t.Setenv("S3_FORCE_PATH_STYLE", "")
+	t.Setenv("S3_REGION", "")
+	assert.Equal(t, &S3Endpoint{}, MakeS3Endpoint())
+
+	t.Setenv("S3_ENDPOINT_URL", "http://minio:9000")
+	t.Setenv("S3_FORCE_PATH_STYLE", "true")
+	t.Setenv("S3_REGION", "auto")
+	endpoint := MakeS3Endpoint()
+	assert.Equal(t, &S3Endpoint{URL: "http://minio:9000", UsePathStyle: true, Region: "auto"}, endpoint)
+
+	options := s3.Options{Region: "us-east-1"}
+	endpoint.options(&options)
+	assert.Equal(t, "http://minio:9000", aws.ToString(options.BaseEndpoint))
+	assert.Equal(t, "auto", options.Region)
+	assert.True(t, options.UsePathStyle)
+	assert.Equal(t, aws.RequestChecksumCalculationWhenRequired, options.RequestChecksumCalculation)
+}
+
+// fakeS3Server A minimal S3 compatible server, like MinIO, which serves path style HEAD, GET (including byte ranges
+// and If-Match) and PUT requests from memory.
+type fakeS3Server struct {
+	mu       sync.Mutex
+	objects  map[string][]byte // Keyed by bucket/key
+	requests []*http.Request
+}
+
+func (f *fakeS3Server) etag(data []byte) string {
+	sum := md5.Sum(data)
+	return fmt.Sprintf(`"%s"`, hex.EncodeToString(sum[:]))
+}
+
+func (f *fakeS3Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, r)
+
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	data, ok := f.objects[name]
+	switch r.Method {
+	case http.MethodPut:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.objects[name] = body
+		w.Header().Set("ETag", f.etag(body))
+		w.WriteHeader(http.StatusOK)
+		return
+	case http.MethodHead, http.MethodGet:
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == http.MethodGet {
+				fmt.Fprintf(w, "<Error><Code>NoSuchKey</Code><Message>%s</Message></Error>", name)
+			}
+			return
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	etag := f.etag(data)
+	if match := r.Header.Get("If-Match"); match != "" && match != etag {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+
+	w.Header().Set("ETag", etag)
+	status, body := http.StatusOK, data
+	var start, end int
+	if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); err == nil {
+		end = min(end, len(data)-1)
+		status, body = http.StatusPartialContent, data[start:end+1]
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+	}
+
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(body)))
+	w.WriteHeader(status)
+	if r.Method == http.MethodGet {
+		w.Write(body)
+	}
+}
+
+func TestS3Client_CompatibleEndpoint(t *testing.T) {
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+
+	fake := &fakeS3Server{objects: map[string][]byte{"test-bucket/mods/Mod.zip": data}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	t.Setenv("BUCKET_NAME", "test-bucket")
+	t.Setenv("S3_ENDPOINT_URL", server.URL)
+	t.Setenv("S3_FORCE_PATH_STYLE", "true")
+	t.Setenv("S3_REGION", "auto")
+	t.Setenv("DOWNLOAD_CACHE_DIR", "")
+
+	cfg := aws.Config{
+		Region: "us-east-1",
+		Credentials: aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "minioadmin", SecretAccessKey: "minioadmin"}, nil
+		}),
+	}
+	s3Client := MakeS3Client(cfg, nil)
+
+	for _, concurrency := range []int{1, 4} {
+		t.Run(fmt.Sprintf("download with concurrency %d", concurrency), func(t *testing.T) {
+			s3Client.PartSize, s3Client.Concurrency = 300, concurrency
+			destination := filepath.Join(t.TempDir(), "Mod.zip")
+			result, err := s3Client.DownloadFile(&FileManager{Op: WRITE, Prefix: "mods/Mod.zip", FileName: "Mod.zip", FileDestinationPath: destination})
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(data)), result.Bytes)
+			assert.Equal(t, ChecksumETag, result.Verified)
+		})
+	}
+
+	t.Run("upload", func(t *testing.T) {
+		key, err := s3Client.UploadIcon("mods/Mod.zip", []byte("png"))
+		require.NoError(t, err)
+		assert.Equal(t, []byte("png"), fake.objects["test-bucket/"+key])
+	})
+
+	t.Run("missing object", func(t *testing.T) {
+		_, err := s3Client.DownloadFile(&FileManager{Op: WRITE, Prefix: "mods/Missing.zip", FileName: "Missing.zip", FileDestinationPath: filepath.Join(t.TempDir(), "Missing.zip")})
+		assert.True(t, isNotFound(err), "DownloadFile() error = %v, want not found", err)
+	})
+
+	// Every request used path style addressing against the custom endpoint and was signed for the overridden region
+	require.NotEmpty(t, fake.requests)
+	for _, r := range fake.requests {
+		assert.True(t, strings.HasPrefix(r.URL.Path, "/test-bucket/"), "%s %s is not path style", r.Method, r.URL.Path)
+		assert.Contains(t, r.Header.Get("Authorization"), "/auto/s3/aws4_request")
+	}
+}
@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	log "github.com/sirupsen/logrus"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// getEnvInt64 Reads an integer from the given environment variable returning the fallback when the variable is unset
+// or cannot be parsed.
+func getEnvInt64(name string, fallback int64) int64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		log.Warnf("invalid value for %s: %s, using default: %d", name, value, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+// getEnvInt Reads an integer from the given environment variable returning the fallback when the variable is unset
+// or cannot be parsed.
+func getEnvInt(name string, fallback int) int {
+	return int(getEnvInt64(name, int64(fallback)))
+}
+
+// getEnvList Reads a comma separated list from the given environment variable ignoring empty values.
+func getEnvList(name string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(name), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"os"
+)
+
+// EventSchemaVersion The version of the event payloads published to RabbitMQ. Bump this when a field is removed or
+// changes meaning so consumers can handle both shapes.
+const EventSchemaVersion = 1
+
+const (
+	InstallStartedType   = "InstallStarted"
+	InstallProgressType  = "InstallProgress"
+	InstallSucceededType = "InstallSucceeded"
+	InstallFailedType    = "InstallFailed"
+)
+
+// legacyEventTypes Maps event types to the message type existing consumers listened for before events were typed.
+var legacyEventTypes = map[string]string{
+	InstallSucceededType: "PreStop",
+	InstallFailedType:    "Failure",
+}
+
+// Event A typed payload published to the valheim-server-status exchange.
+type Event interface {
+	EventType() string
+}
+
+// EventMetadata Fields common to every event identifying which Job published it and what it was doing.
+type EventMetadata struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	ContainerName string `json:"containerName"`
+	ContainerType string `json:"containerType"`
+	Operation     string `json:"operation"`
+	Prefix        string `json:"prefix"`
+}
+
+// MakeEventMetadata Creates the common event fields for the Job described by the file manager.
+func MakeEventMetadata(fileManager *FileManager) EventMetadata {
+	return EventMetadata{
+		SchemaVersion: EventSchemaVersion,
+		ContainerName: os.Getenv("HOSTNAME"),
+		ContainerType: "file-install",
+		Operation:     fileManager.Op,
+		Prefix:        fileManager.Prefix,
+	}
+}
+
+// InstallStarted Published once the Job has parsed its arguments and is about to start work.
+type InstallStarted struct {
+	EventMetadata
+}
+
+func (e *InstallStarted) EventType() string {
+	return InstallStartedType
+}
+
+// InstallProgress Published as the Job completes each stage i.e. scaling down the server or downloading the file.
+type InstallProgress struct {
+	EventMetadata
+	Stage   string `json:"stage"`
+	Message string `json:"message"`
+}
+
+func (e *InstallProgress) EventType() string {
+	return InstallProgressType
+}
+
+// InstallSucceeded Published once the operation has been applied to the PVC and its file record saved. The file records
+// are hearthhub-common models which have no columns for a file's digest, a mod's version or a world's metadata so they
+// aren't saved to the database. This event is the only place they're published and subscribers which need them have to
+// keep them.
+type InstallSucceeded struct {
+	EventMetadata
+	Bytes        int64          `json:"bytes,omitempty"`
+	SHA256       string         `json:"sha256,omitempty"`
+	Verified     string         `json:"verified,omitempty"` // The checksum algorithm the download was verified against S3 with
+	Package      string         `json:"package,omitempty"`  // The name from the mod's manifest.json when it has one
+	Version      string         `json:"version,omitempty"`
+	Dependencies []string       `json:"dependencies,omitempty"` // The Author-Name-Version of each dependency installed with the mod
+	World        *WorldMetadata `json:"world,omitempty"`        // The metadata of a world written or restored from its .fwl file
+}
+
+func (e *InstallSucceeded) EventType() string {
+	return InstallSucceededType
+}
+
+// InstallFailed Published when the Job fails so the frontend and Discord bot can tell the user instead of waiting on
+// an install which will never finish.
+type InstallFailed struct {
+	EventMetadata
+	Stage string `json:"stage"`
+	Error string `json:"error"`
+}
+
+func (e *InstallFailed) EventType() string {
+	return InstallFailedType
+}
@@ -8,6 +8,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 type FileManager struct {
@@ -17,8 +18,12 @@ type FileManager struct {
 	Destination         string // The destination dir /Valheim/BepInEx/plugins
 	Archive             bool
 	Op                  string
-	FileName            string // The name of the file: Mod.zip
-	FileDestinationPath string // The path on PVC which includes the destination and file name i.e /Valheim/BepInEx/plugins/Mod.zip
+	FileName            string   // The name of the file: Mod.zip
+	FileDestinationPath string   // The path on PVC which includes the destination and file name i.e /Valheim/BepInEx/plugins/Mod.zip
+	Backup              string   // What a backup op uploads: worlds, config or plugins
+	World               string   // The world a restore op restores i.e. MyWorld
+	Timestamp           string   // The timestamp of the backup a restore op restores i.e. 20250101120000
+	ListedDirs          []string // The PVC directories whose files are logged once an op is done
 	ArchiveHandler      *Archive
 }
 
@@ -26,27 +31,61 @@ var (
 	COPY        = "copy"
 	WRITE       = "write"
 	DELETE      = "delete"
+	DISABLE     = "disable"
+	ENABLE      = "enable"
+	PRUNE_CACHE = "prune_cache"
+	BACKUP      = "backup"
+	RESTORE     = "restore"
+	PRUNE       = "prune"
 	BACKUPS_DIR = "/root/.config/unity3d/IronGate/Valheim/worlds_local/"
 	PLUGINS_DIR = "/valheim/BepInEx/plugins/"
 	CONFIG_DIR  = "/valheim/BepInEx/config"
 )
 
 func MakeFileManager(flagSet *flag.FlagSet, args []string) (*FileManager, error) {
-	var discordId, refreshToken, prefix, destination, archive, op string
+	var discordId, refreshToken, prefix, destination, archive, op, layout, force, backup, world, timestamp string
 	flagSet.StringVar(&discordId, "discord_id", "", "Discord ID")
 	flagSet.StringVar(&refreshToken, "refresh_token", "", "Refresh token")
 	flagSet.StringVar(&prefix, "prefix", "", "S3 prefix name including the extension. ex: file.zip")
 	flagSet.StringVar(&destination, "destination", "", "PVC volume destination")
 	flagSet.StringVar(&archive, "archive", "", "If the file being downloaded is an archive and needs unpacked.")
-	flagSet.StringVar(&op, "op", "", "Operation to perform either \"write\" or \"delete\"")
+	flagSet.StringVar(&op, "op", "", "Operation to perform either \"write\", \"delete\", \"copy\", \"disable\", \"enable\", \"prune_cache\", \"backup\", \"restore\" or \"prune\"")
+	flagSet.StringVar(&layout, "layout", LayoutFlat, "How an archive is unpacked either \"flat\" or \"thunderstore\"")
+	flagSet.StringVar(&force, "force", "false", "Install an archive even if its files collide with other installed mods.")
+	flagSet.StringVar(&backup, "backup", "", "What a backup op uploads either \"worlds\", \"config\" or \"plugins\"")
+	flagSet.StringVar(&world, "world", "", "The world a restore op restores or a prune op prunes the backups of. ex: MyWorld")
+	flagSet.StringVar(&timestamp, "timestamp", "", "The timestamp of the backup a restore op restores. ex: 20250101120000")
 
 	// Parse flags
 	if err := flagSet.Parse(args); err != nil {
 		return nil, fmt.Errorf("failed to parse flags: %v", err)
 	}
 
-	if op != WRITE && op != DELETE && op != COPY {
-		return nil, errors.New("invalid \"op\" argument specified. Must be one of: write, delete, copy")
+	if op != WRITE && op != DELETE && op != COPY && op != DISABLE && op != ENABLE && op != PRUNE_CACHE && op != BACKUP && op != RESTORE && op != PRUNE {
+		return nil, errors.New("invalid \"op\" argument specified. Must be one of: write, delete, copy, disable, enable, prune_cache, backup, restore, prune")
+	}
+
+	if op == BACKUP && backup != BackupWorlds && backup != BackupConfig && backup != BackupPlugins {
+		return nil, errors.New("invalid \"backup\" argument specified. Must be one of: worlds, config, plugins")
+	}
+
+	if op == PRUNE && world != "" {
+		if err := validateWorldName(world); err != nil {
+			return nil, fmt.Errorf("invalid \"world\" argument specified: %w", err)
+		}
+	}
+
+	if op == RESTORE {
+		if err := validateWorldName(world); err != nil {
+			return nil, fmt.Errorf("invalid \"world\" argument specified: %w", err)
+		}
+		if _, err := time.Parse(BackupTimestampLayout, timestamp); err != nil {
+			return nil, fmt.Errorf("invalid \"timestamp\" argument specified %q. Must be formatted as %s", timestamp, BackupTimestampLayout)
+		}
+	}
+
+	if layout != LayoutFlat && layout != LayoutThunderstore {
+		return nil, errors.New("invalid \"layout\" argument specified. Must be one of: flat, thunderstore")
 	}
 
 	var isArchive bool
@@ -61,7 +100,7 @@ func MakeFileManager(flagSet *flag.FlagSet, args []string) (*FileManager, error)
 		return nil, errors.New("-discord_id and -refresh_token args are required")
 	}
 
-	log.Infof("Discord ID: %s, file name: %s, destination: %s is_archive: %v operation: %s", discordId, prefix, destination, isArchive, op)
+	log.Infof("Discord ID: %s, file name: %s, destination: %s is_archive: %v operation: %s layout: %s", discordId, prefix, destination, isArchive, op, layout)
 
 	fileName := filepath.Base(prefix)
 	var finalPath string
@@ -71,6 +110,21 @@ func MakeFileManager(flagSet *flag.FlagSet, args []string) (*FileManager, error)
 		return nil, errors.New("\"copy\" operation and archive cannot be used together")
 	}
 
+	if (op == DISABLE || op == ENABLE) && !isArchive {
+		return nil, fmt.Errorf("\"%s\" operation can only be used with archives", op)
+	}
+
+	if (op == BACKUP || op == RESTORE || op == PRUNE) && isArchive {
+		return nil, fmt.Errorf("\"%s\" operation and archive cannot be used together", op)
+	}
+
+	// Restores replace the world's own files rather than anything named by the prefix.
+	if op == RESTORE {
+		fileName = world + ".db"
+		destination = BACKUPS_DIR
+		temporaryDestination = destination
+	}
+
 	if op != COPY {
 		if !strings.HasSuffix(temporaryDestination, "/") {
 			temporaryDestination += "/"
@@ -95,19 +149,43 @@ func MakeFileManager(flagSet *flag.FlagSet, args []string) (*FileManager, error)
 		Op:                  op,
 		FileName:            fileName,
 		FileDestinationPath: finalPath,
+		Backup:              backup,
+		World:               world,
+		Timestamp:           timestamp,
+		ListedDirs:          []string{BACKUPS_DIR, PLUGINS_DIR, CONFIG_DIR},
 		ArchiveHandler: &Archive{
 			ZipFilePath: finalPath,
 			Destination: destination,
+			Layout:      layout,
+			Force:       force == "true",
 		},
 	}, nil
 }
 
-// DoOperation Performs the desired operation specified in the "op" flag. This will either unpack a zip to the
-// specified destination or search through the zip and remove all files corresponding to the zip at the specified
-// destination. This ensures mods can be uninstalled without having to keep track of which files belong to which
-// mod with any type of manifest. Note: copy operations don't need special handling here since they are technically
-// just write ops directed at a file rather than a dir (overwriting the file).
+// DoOperation Performs the desired operation specified in the "op" flag. This will either unpack an archive to the
+// specified destination or remove all files the archive installed at the specified destination using the manifest
+// written when it was unpacked. Disable and enable ops move the files an archive installed out of and back into place
+// and restore ops replace a world with one of its backups.
+// Note: copy operations don't need special handling here since they are technically just write ops directed at a file
+// rather than a dir (overwriting the file).
 func (f *FileManager) DoOperation() error {
+	if f.Op == PRUNE_CACHE || f.Op == BACKUP || f.Op == PRUNE {
+		return fmt.Errorf("\"%s\" operations have no downloaded file to operate on", f.Op)
+	}
+
+	if f.Op == DISABLE {
+		return f.ArchiveHandler.Disable()
+	}
+
+	if f.Op == ENABLE {
+		return f.ArchiveHandler.Enable()
+	}
+
+	if f.Op == RESTORE {
+		_, err := f.Restore()
+		return err
+	}
+
 	if f.Op == WRITE || f.Op == COPY {
 		if f.Archive {
 			// Unpack the file from /valheim/BepInEx/plugins/ValheimPlus.zip to /valheim/BepInEx/plugins/
@@ -122,7 +200,15 @@ func (f *FileManager) DoOperation() error {
 	} else {
 		log.Infof("job is a delete operation: is archive: %v", f.Archive)
 		if f.Archive {
-			f.ArchiveHandler.RemoveFilesFromZip()
+			report, err := f.ArchiveHandler.RemoveFilesFromZip()
+			if err != nil {
+				return err
+			}
+
+			log.Infof("removed %d files, %d already missing", len(report.Removed), len(report.Missing))
+			if len(report.Modified) > 0 {
+				log.Warnf("%d files changed since install and were left in place: %v", len(report.Modified), report.Modified)
+			}
 		} else {
 			// Handle removing .db and .fwl files when the op is a remove (similar to the s3 sync but opposite)
 			if strings.HasSuffix(f.Prefix, ".db") {
@@ -144,40 +230,18 @@ func (f *FileManager) DoOperation() error {
 		}
 	}
 
-	log.Infof("current state of files in: %s", BACKUPS_DIR)
-	files, err := f.ListFiles(BACKUPS_DIR, func(fileName string) bool {
-		return true
-	})
-	if err != nil {
-		return err
-	}
-
-	for _, file := range files {
-		log.Infof("%s - %v", file.Name(), file.Size())
-	}
-
-	log.Infof("current state of files in: %s", PLUGINS_DIR)
-	files, err = f.ListFiles(PLUGINS_DIR, func(fileName string) bool {
-		return true
-	})
-	if err != nil {
-		return err
-	}
-
-	for _, file := range files {
-		log.Infof("%s - %v", file.Name(), file.Size())
-	}
-
-	log.Infof("current state of files in: %s", CONFIG_DIR)
-	files, err = f.ListFiles(CONFIG_DIR, func(fileName string) bool {
-		return true
-	})
-	if err != nil {
-		return err
-	}
+	for _, dir := range f.ListedDirs {
+		log.Infof("current state of files in: %s", dir)
+		files, err := f.ListFiles(dir, func(fileName string) bool {
+			return true
+		})
+		if err != nil {
+			return err
+		}
 
-	for _, file := range files {
-		log.Infof("%s - %v", file.Name(), file.Size())
+		for _, file := range files {
+			log.Infof("%s - %v", file.Name(), file.Size())
+		}
 	}
 
 	return nil
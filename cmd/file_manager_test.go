@@ -25,6 +25,43 @@ func TestMakeFileManager(t *testing.T) {
 		assert.Equal(t, "write", manager.Op)
 		assert.Equal(t, "file.zip", manager.FileName)
 		assert.Equal(t, "/valheim/plugins/file.zip", manager.FileDestinationPath)
+		assert.Equal(t, LayoutFlat, manager.ArchiveHandler.Layout)
+	})
+
+	t.Run("thunderstore layout", func(t *testing.T) {
+		args := []string{"-discord_id", "id", "-refresh_token", "token", "-prefix", "/prefix/file.zip",
+			"-destination", "/valheim/BepInEx/plugins", "-archive", "true", "-op", "write", "-layout", "thunderstore"}
+
+		flagSet := flag.NewFlagSet("test", flag.ContinueOnError)
+
+		manager, err := MakeFileManager(flagSet, args)
+		assert.Nil(t, err)
+		assert.Equal(t, LayoutThunderstore, manager.ArchiveHandler.Layout)
+		assert.False(t, manager.ArchiveHandler.Force)
+	})
+
+	t.Run("force", func(t *testing.T) {
+		args := []string{"-discord_id", "id", "-refresh_token", "token", "-prefix", "/prefix/file.zip",
+			"-destination", "/valheim/BepInEx/plugins", "-archive", "true", "-op", "write", "-force", "true"}
+
+		flagSet := flag.NewFlagSet("test", flag.ContinueOnError)
+
+		manager, err := MakeFileManager(flagSet, args)
+		assert.Nil(t, err)
+		assert.True(t, manager.ArchiveHandler.Force)
+	})
+
+	t.Run("restore", func(t *testing.T) {
+		args := []string{"-discord_id", "id", "-refresh_token", "token", "-op", "restore", "-world", "MyWorld", "-timestamp", "20250101120000"}
+
+		flagSet := flag.NewFlagSet("test", flag.ContinueOnError)
+
+		manager, err := MakeFileManager(flagSet, args)
+		assert.Nil(t, err)
+		assert.Equal(t, "MyWorld", manager.World)
+		assert.Equal(t, "20250101120000", manager.Timestamp)
+		assert.Equal(t, "MyWorld.db", manager.FileName)
+		assert.Equal(t, filepath.Join(BACKUPS_DIR, "MyWorld.db"), manager.FileDestinationPath)
 	})
 
 	t.Run("dest missing end slash", func(t *testing.T) {
@@ -71,6 +108,86 @@ func TestMakeFileManager(t *testing.T) {
 			args:        []string{"-discord_id=123", "-refresh_token=abc", "-prefix=file.zip", "-destination=/data", "-archive=true", "-op=invalid"},
 			expectError: true,
 		},
+		{
+			name:        "disable archive",
+			args:        []string{"-discord_id=123", "-refresh_token=abc", "-prefix=file.zip", "-destination=/data", "-archive=true", "-op=disable"},
+			expectError: false,
+		},
+		{
+			name:        "enable non-archive",
+			args:        []string{"-discord_id=123", "-refresh_token=abc", "-prefix=file.zip", "-destination=/data", "-archive=false", "-op=enable"},
+			expectError: true,
+		},
+		{
+			name:        "prune cache",
+			args:        []string{"-discord_id=123", "-refresh_token=abc", "-destination=/data", "-op=prune_cache"},
+			expectError: false,
+		},
+		{
+			name:        "backup worlds",
+			args:        []string{"-discord_id=123", "-refresh_token=abc", "-prefix=MyWorld", "-destination=/data", "-op=backup", "-backup=worlds"},
+			expectError: false,
+		},
+		{
+			name:        "backup missing what to back up",
+			args:        []string{"-discord_id=123", "-refresh_token=abc", "-destination=/data", "-op=backup"},
+			expectError: true,
+		},
+		{
+			name:        "backup invalid",
+			args:        []string{"-discord_id=123", "-refresh_token=abc", "-destination=/data", "-op=backup", "-backup=mods"},
+			expectError: true,
+		},
+		{
+			name:        "backup archive",
+			args:        []string{"-discord_id=123", "-refresh_token=abc", "-prefix=file.zip", "-destination=/data", "-archive=true", "-op=backup", "-backup=plugins"},
+			expectError: true,
+		},
+		{
+			name:        "restore",
+			args:        []string{"-discord_id=123", "-refresh_token=abc", "-op=restore", "-world=MyWorld", "-timestamp=20250101120000"},
+			expectError: false,
+		},
+		{
+			name:        "restore missing world",
+			args:        []string{"-discord_id=123", "-refresh_token=abc", "-op=restore", "-timestamp=20250101120000"},
+			expectError: true,
+		},
+		{
+			name:        "restore world outside the backups directory",
+			args:        []string{"-discord_id=123", "-refresh_token=abc", "-op=restore", "-world=../MyWorld", "-timestamp=20250101120000"},
+			expectError: true,
+		},
+		{
+			name:        "restore invalid timestamp",
+			args:        []string{"-discord_id=123", "-refresh_token=abc", "-op=restore", "-world=MyWorld", "-timestamp=2025-01-01"},
+			expectError: true,
+		},
+		{
+			name:        "restore archive",
+			args:        []string{"-discord_id=123", "-refresh_token=abc", "-op=restore", "-world=MyWorld", "-timestamp=20250101120000", "-archive=true"},
+			expectError: true,
+		},
+		{
+			name:        "prune",
+			args:        []string{"-discord_id=123", "-refresh_token=abc", "-op=prune"},
+			expectError: false,
+		},
+		{
+			name:        "prune world",
+			args:        []string{"-discord_id=123", "-refresh_token=abc", "-op=prune", "-world=MyWorld"},
+			expectError: false,
+		},
+		{
+			name:        "prune world outside the backups directory",
+			args:        []string{"-discord_id=123", "-refresh_token=abc", "-op=prune", "-world=../MyWorld"},
+			expectError: true,
+		},
+		{
+			name:        "invalid layout",
+			args:        []string{"-discord_id=123", "-refresh_token=abc", "-prefix=file.zip", "-destination=/data", "-archive=true", "-op=write", "-layout=nested"},
+			expectError: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -159,6 +276,22 @@ func TestDoOperation(t *testing.T) {
 			expectedError: true,
 			checkFunc:     nil,
 		},
+		{
+			name: "Delete archive file with missing zip",
+			fileManager: &FileManager{
+				Op:                  "delete",
+				Archive:             true,
+				FileDestinationPath: filepath.Join(tempDir, "missing.zip"),
+				Destination:         tempDir,
+				ArchiveHandler: &Archive{
+					ZipFilePath: filepath.Join(tempDir, "missing.zip"),
+					Destination: tempDir,
+				},
+			},
+			setupFunc:     nil,
+			expectedError: true,
+			checkFunc:     nil,
+		},
 		{
 			name: "Write archive file",
 			fileManager: &FileManager{
@@ -191,6 +324,9 @@ func TestDoOperation(t *testing.T) {
 				}
 			}
 
+			// DoOperation lists the contents of the PVC directories when it's done so point it somewhere which exists
+			tt.fileManager.ListedDirs = []string{tempDir}
+
 			// Execute
 			err := tt.fileManager.DoOperation()
 
@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"errors"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// LayoutFlat Unpacks every entry in the archive as is into the destination.
+	LayoutFlat = "flat"
+
+	// LayoutThunderstore Unpacks a Thunderstore package routing each of its folders to the matching BepInEx directory.
+	// The destination is expected to be BepInEx/plugins.
+	LayoutThunderstore = "thunderstore"
+)
+
+// thunderstoreDirs The BepInEx directories a Thunderstore package can install into. Plugins and patchers are installed
+// into a folder named after the package so packages can't overwrite each other's files. Config and core files are
+// installed at the top level since that's where BepInEx looks for them.
+var thunderstoreDirs = map[string]bool{
+	"plugins":  true,
+	"patchers": true,
+	"config":   false,
+	"core":     false,
+}
+
+// packageName Returns the name of the package an archive contains which is the archive's name without its extension.
+func packageName(archivePath string) string {
+	name := filepath.Base(archivePath)
+	for _, ext := range []string{".tar.gz", ".tar.zst"} {
+		if strings.HasSuffix(strings.ToLower(name), ext) {
+			return name[:len(name)-len(ext)]
+		}
+	}
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// installRoot Returns the directory every path in the archive is installed relative to. For Thunderstore packages this
+// is the BepInEx directory the plugins destination lives in.
+func (a *Archive) installRoot() string {
+	if a.Layout == LayoutThunderstore {
+		return filepath.Dir(filepath.Clean(a.Destination))
+	}
+	return filepath.Clean(a.Destination)
+}
+
+// thunderstoreWrapper Returns the folder every entry is nested in when a package has been zipped up with a wrapper
+// folder around it i.e. Author-Mod-1.0.0/plugins/Mod.dll, otherwise an empty string. Wrappers are stripped repeatedly
+// until a BepInEx directory or a file is found at the top level.
+func thunderstoreWrapper(names []string) string {
+	var wrapper string
+	for {
+		var top string
+		for _, name := range names {
+			rel := strings.TrimPrefix(name, wrapper)
+			if rel == "" {
+				continue
+			}
+
+			first, _, nested := strings.Cut(rel, "/")
+			if !nested || (top != "" && first != top) {
+				return wrapper
+			}
+			top = first
+		}
+
+		if _, ok := thunderstoreDirs[strings.ToLower(top)]; top == "" || ok || strings.EqualFold(top, "BepInEx") {
+			return wrapper
+		}
+		wrapper += top + "/"
+	}
+}
+
+// thunderstoreTarget Maps the name of an entry in a Thunderstore package to the directory it installs into relative
+// to the BepInEx root and its path within that directory. Anything which isn't in one of the BepInEx directories,
+// including the package's manifest.json, icon.png and README.md, is installed into the package's plugin folder so
+// nothing is left at the root of the plugins directory. An empty dir means the entry shouldn't be installed.
+func thunderstoreTarget(name, wrapper, pkg string) (string, string) {
+	rel := strings.TrimPrefix(name, wrapper)
+	if first, rest, _ := strings.Cut(rel, "/"); strings.EqualFold(first, "BepInEx") {
+		rel = rest
+	}
+	if rel == "" {
+		return "", ""
+	}
+
+	first, rest, _ := strings.Cut(rel, "/")
+	dir := strings.ToLower(first)
+	if perPackage, ok := thunderstoreDirs[dir]; ok {
+		if perPackage {
+			dir = path.Join(dir, pkg)
+		}
+		return dir, rest
+	}
+	return path.Join("plugins", pkg), rel
+}
+
+// resolveThunderstoreEntries Resolves every entry in a Thunderstore package to its path on disk. Each entry is resolved
+// within the BepInEx directory it's routed to so an entry like config/../BepInEx.cfg is rejected even though it's
+// inside the BepInEx root. Entries which aren't installed i.e. a wrapper folder resolve to an empty path.
+func (a *Archive) resolveThunderstoreEntries(entries []archiveEntry) ([]string, error) {
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = strings.ReplaceAll(entry.Name, `\`, "/")
+	}
+
+	root := a.installRoot()
+	wrapper := thunderstoreWrapper(names)
+	pkg := packageName(a.ZipFilePath)
+	paths := make([]string, len(entries))
+	for i, entry := range entries {
+		if strings.HasPrefix(names[i], "/") || filepath.IsAbs(entry.Name) {
+			return nil, &UnsafePathError{Entry: entry.Name, Reason: "absolute path"}
+		}
+
+		dir, rel := thunderstoreTarget(names[i], wrapper, pkg)
+		if dir == "" {
+			continue
+		}
+
+		base := filepath.Join(root, filepath.FromSlash(dir))
+		if strings.Trim(rel, "/") == "" {
+			paths[i] = base
+			continue
+		}
+
+		path, err := resolveEntryPath(base, rel)
+		var unsafePathErr *UnsafePathError
+		if errors.As(err, &unsafePathErr) {
+			unsafePathErr.Entry = entry.Name
+		}
+		if err != nil {
+			return nil, err
+		}
+		paths[i] = path
+	}
+	return paths, nil
+}
@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPackageName(t *testing.T) {
+	tests := map[string]string{
+		"/valheim/BepInEx/plugins/ValheimPlus.zip": "ValheimPlus",
+		"Author-Mod-1.0.0.zip":                     "Author-Mod-1.0.0",
+		"Mod.tar.gz":                               "Mod",
+		"Mod.TAR.ZST":                              "Mod",
+		"Mod.7z":                                   "Mod",
+		"Mod":                                      "Mod",
+	}
+
+	for archive, want := range tests {
+		assert.Equal(t, want, packageName(archive), archive)
+	}
+}
+
+func TestUnzipFile_ThunderstoreLayout(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []testZipEntry
+		want    map[string]string // Paths relative to the BepInEx directory
+	}{
+		{
+			name: "package",
+			entries: []testZipEntry{
+				{name: "manifest.json", content: "{}"},
+				{name: "icon.png", content: "icon"},
+				{name: "README.md", content: "readme"},
+				{name: "plugins/", mode: os.ModeDir | 0755},
+				{name: "plugins/Mod.dll", content: "dll"},
+				{name: "plugins/Assets/a.png", content: "png"},
+				{name: "config/mod.cfg", content: "cfg"},
+				{name: "patchers/Patcher.dll", content: "patcher"},
+				{name: "core/Core.dll", content: "core"},
+			},
+			want: map[string]string{
+				"plugins/Mod/manifest.json": "{}",
+				"plugins/Mod/icon.png":      "icon",
+				"plugins/Mod/README.md":     "readme",
+				"plugins/Mod/Mod.dll":       "dll",
+				"plugins/Mod/Assets/a.png":  "png",
+				"config/mod.cfg":            "cfg",
+				"patchers/Mod/Patcher.dll":  "patcher",
+				"core/Core.dll":             "core",
+			},
+		},
+		{
+			name: "wrapper folder and BepInEx tree",
+			entries: []testZipEntry{
+				{name: "Author-Mod-1.0.0/", mode: os.ModeDir | 0755},
+				{name: "Author-Mod-1.0.0/manifest.json", content: "{}"},
+				{name: "Author-Mod-1.0.0/BepInEx/plugins/Mod.dll", content: "dll"},
+				{name: "Author-Mod-1.0.0/BepInEx/config/mod.cfg", content: "cfg"},
+			},
+			want: map[string]string{
+				"plugins/Mod/manifest.json": "{}",
+				"plugins/Mod/Mod.dll":       "dll",
+				"config/mod.cfg":            "cfg",
+			},
+		},
+		{
+			name: "nested wrapper folders and mixed case",
+			entries: []testZipEntry{
+				{name: `Mod\Mod\Plugins\Mod.dll`, content: "dll"},
+				{name: "Mod/Mod/Config/mod.cfg", content: "cfg"},
+			},
+			want: map[string]string{
+				"plugins/Mod/Mod.dll": "dll",
+				"config/mod.cfg":      "cfg",
+			},
+		},
+		{
+			name: "loose plugin",
+			entries: []testZipEntry{
+				{name: "manifest.json", content: "{}"},
+				{name: "Mod.dll", content: "dll"},
+			},
+			want: map[string]string{
+				"plugins/Mod/manifest.json": "{}",
+				"plugins/Mod/Mod.dll":       "dll",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bepInExDir := t.TempDir()
+			pluginsDir := filepath.Join(bepInExDir, "plugins")
+			createTestFiles(t, map[string]string{"config/other.cfg": "other"}, bepInExDir)
+
+			zipPath := filepath.Join(pluginsDir, "Mod.zip")
+			require.NoError(t, os.MkdirAll(pluginsDir, 0755))
+			require.NoError(t, os.Rename(createTestZipEntries(t, tt.entries), zipPath))
+
+			a := &Archive{ZipFilePath: zipPath, Destination: pluginsDir, Layout: LayoutThunderstore}
+			require.NoError(t, a.UnzipFile())
+
+			for name, content := range tt.want {
+				assertFileContent(t, filepath.Join(bepInExDir, name), content)
+			}
+
+			// Only the archive and the package's folder should be at the root of the plugins directory
+			entries, err := os.ReadDir(pluginsDir)
+			require.NoError(t, err)
+			var names []string
+			for _, entry := range entries {
+				names = append(names, entry.Name())
+			}
+			assert.ElementsMatch(t, []string{"Mod", "Mod.zip", workDirName}, names)
+
+			report, err := a.RemoveFilesFromZip()
+			require.NoError(t, err)
+			assert.Len(t, report.Removed, len(tt.want))
+			for name := range tt.want {
+				_, err := os.Stat(filepath.Join(bepInExDir, name))
+				assert.True(t, os.IsNotExist(err), "%s should have been removed", name)
+			}
+			_, err = os.Stat(filepath.Join(pluginsDir, "Mod"))
+			assert.True(t, os.IsNotExist(err), "the package's plugin folder should have been removed")
+			assertFileContent(t, filepath.Join(bepInExDir, "config/other.cfg"), "other")
+		})
+	}
+}
+
+func TestUnzipFile_ThunderstoreUnsafeEntries(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry string
+	}{
+		{name: "escape routed directory", entry: "config/../BepInEx.cfg"},
+		{name: "escape BepInEx", entry: "plugins/../../../evil.txt"},
+		{name: "absolute path", entry: "/tmp/evil.txt"},
+		{name: "windows drive letter", entry: `C:\evil.txt`},
+		{name: "work dir", entry: "plugins/../../plugins/.hearthhub/manifests/Other.zip.json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bepInExDir := t.TempDir()
+			pluginsDir := filepath.Join(bepInExDir, "plugins")
+			require.NoError(t, os.MkdirAll(pluginsDir, 0755))
+
+			zipPath := createTestZipEntries(t, []testZipEntry{{name: "plugins/safe.txt", content: "safe"}, {name: tt.entry, content: "evil"}})
+			defer os.Remove(zipPath)
+
+			a := &Archive{ZipFilePath: zipPath, Destination: pluginsDir, Layout: LayoutThunderstore}
+			err := a.UnzipFile()
+			var unsafePathErr *UnsafePathError
+			require.True(t, errors.As(err, &unsafePathErr), "UnzipFile() error = %v, want *UnsafePathError", err)
+			assert.Equal(t, tt.entry, unsafePathErr.Entry)
+
+			_, err = os.Stat(filepath.Join(pluginsDir, packageName(zipPath), "safe.txt"))
+			assert.True(t, os.IsNotExist(err), "safe.txt should not have been extracted from a rejected archive")
+		})
+	}
+}
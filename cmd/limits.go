@@ -0,0 +1,146 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+)
+
+const (
+	defaultMaxTotalSizeMb = 2048
+	defaultMaxEntries     = 20000
+	defaultMaxEntrySizeMb = 1024
+	defaultMaxRatio       = 100
+)
+
+// The limits an archive can exceed.
+const (
+	LimitTotalSize = "total size"
+	LimitEntries   = "entries"
+	LimitEntrySize = "entry size"
+	LimitRatio     = "compression ratio"
+)
+
+// ExtractionLimits Caps how much an archive can extract so a single hostile upload (a "zip bomb") can't fill the PVC
+// shared by every server on it. A limit of 0 is not enforced.
+type ExtractionLimits struct {
+	MaxTotalSize int64 // Total uncompressed bytes across every entry
+	MaxEntries   int
+	MaxEntrySize int64 // Uncompressed bytes of any one entry
+	MaxRatio     int64 // Total uncompressed bytes divided by the size of the archive
+}
+
+// LimitExceededError is returned when extracting an archive would cross one of its ExtractionLimits.
+type LimitExceededError struct {
+	Archive string
+	Entry   string // The entry which crossed the limit, empty for limits on the whole archive
+	Limit   string
+	Value   int64
+	Max     int64
+}
+
+func (e *LimitExceededError) Error() string {
+	if e.Entry != "" {
+		return fmt.Sprintf("%s exceeds the %s limit of %d with entry %q (%d)", e.Archive, e.Limit, e.Max, e.Entry, e.Value)
+	}
+	return fmt.Sprintf("%s exceeds the %s limit of %d (%d)", e.Archive, e.Limit, e.Max, e.Value)
+}
+
+// MakeExtractionLimits Creates the extraction limits configured from the EXTRACT_MAX_TOTAL_SIZE_MB,
+// EXTRACT_MAX_ENTRIES, EXTRACT_MAX_ENTRY_SIZE_MB and EXTRACT_MAX_RATIO environment variables.
+func MakeExtractionLimits() *ExtractionLimits {
+	return &ExtractionLimits{
+		MaxTotalSize: getEnvInt64("EXTRACT_MAX_TOTAL_SIZE_MB", defaultMaxTotalSizeMb) * 1024 * 1024,
+		MaxEntries:   getEnvInt("EXTRACT_MAX_ENTRIES", defaultMaxEntries),
+		MaxEntrySize: getEnvInt64("EXTRACT_MAX_ENTRY_SIZE_MB", defaultMaxEntrySizeMb) * 1024 * 1024,
+		MaxRatio:     getEnvInt64("EXTRACT_MAX_RATIO", defaultMaxRatio),
+	}
+}
+
+// extractionMeter Enforces the limits while a single archive is extracted.
+type extractionMeter struct {
+	limits      *ExtractionLimits
+	archive     string
+	archiveSize int64 // Size of the archive on disk
+	total       int64 // Uncompressed bytes read so far
+}
+
+func (l *ExtractionLimits) meter(archivePath string, archiveSize int64) *extractionMeter {
+	return &extractionMeter{limits: l, archive: filepath.Base(archivePath), archiveSize: archiveSize}
+}
+
+// checkEntries Checks the entries against the limits using the sizes recorded in the archive so an archive which
+// declares itself too large is rejected before anything is written.
+func (m *extractionMeter) checkEntries(entries []archiveEntry) error {
+	if m.limits.MaxEntries > 0 && len(entries) > m.limits.MaxEntries {
+		return &LimitExceededError{Archive: m.archive, Limit: LimitEntries, Value: int64(len(entries)), Max: int64(m.limits.MaxEntries)}
+	}
+
+	var total int64
+	for _, entry := range entries {
+		if err := m.checkEntrySize(entry, entry.Size); err != nil {
+			return err
+		}
+		total += entry.Size
+	}
+	return m.checkTotal(total)
+}
+
+// checkListed Checks the entries listed so far, of which entry is the last, against the limits. Archives without a
+// central directory are listed by decompressing them so this is checked as each entry is listed to stop as soon as a
+// limit is crossed rather than after the whole archive has been decompressed.
+func (m *extractionMeter) checkListed(count int, entry archiveEntry, total int64) error {
+	if m.limits.MaxEntries > 0 && count > m.limits.MaxEntries {
+		return &LimitExceededError{Archive: m.archive, Limit: LimitEntries, Value: int64(count), Max: int64(m.limits.MaxEntries)}
+	}
+	if err := m.checkEntrySize(entry, entry.Size); err != nil {
+		return err
+	}
+	return m.checkTotal(total)
+}
+
+// reader Wraps the contents of an entry so extraction stops as soon as a limit is crossed. The sizes recorded in an
+// archive's headers can't be trusted so the bytes actually read are counted as well.
+func (m *extractionMeter) reader(entry archiveEntry, r io.Reader) io.Reader {
+	return &meteredReader{meter: m, entry: entry, r: r}
+}
+
+func (m *extractionMeter) checkEntrySize(entry archiveEntry, size int64) error {
+	if m.limits.MaxEntrySize > 0 && size > m.limits.MaxEntrySize {
+		return &LimitExceededError{Archive: m.archive, Entry: entry.Name, Limit: LimitEntrySize, Value: size, Max: m.limits.MaxEntrySize}
+	}
+	return nil
+}
+
+func (m *extractionMeter) checkTotal(total int64) error {
+	if m.limits.MaxTotalSize > 0 && total > m.limits.MaxTotalSize {
+		return &LimitExceededError{Archive: m.archive, Limit: LimitTotalSize, Value: total, Max: m.limits.MaxTotalSize}
+	}
+
+	if m.limits.MaxRatio > 0 && m.archiveSize > 0 && total/m.archiveSize > m.limits.MaxRatio {
+		return &LimitExceededError{Archive: m.archive, Limit: LimitRatio, Value: total / m.archiveSize, Max: m.limits.MaxRatio}
+	}
+	return nil
+}
+
+// meteredReader Counts the bytes read from an entry against the limits.
+type meteredReader struct {
+	meter *extractionMeter
+	entry archiveEntry
+	r     io.Reader
+	read  int64
+}
+
+func (r *meteredReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.read += int64(n)
+	r.meter.total += int64(n)
+
+	if limitErr := r.meter.checkEntrySize(r.entry, r.read); limitErr != nil {
+		return n, limitErr
+	}
+	if limitErr := r.meter.checkTotal(r.meter.total); limitErr != nil {
+		return n, limitErr
+	}
+	return n, err
+}
@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMakeExtractionLimits(t *testing.T) {
+	limits := MakeExtractionLimits()
+	assert.Equal(t, int64(defaultMaxTotalSizeMb*1024*1024), limits.MaxTotalSize)
+	assert.Equal(t, defaultMaxEntries, limits.MaxEntries)
+	assert.Equal(t, int64(defaultMaxEntrySizeMb*1024*1024), limits.MaxEntrySize)
+	assert.Equal(t, int64(defaultMaxRatio), limits.MaxRatio)
+
+	t.Setenv("EXTRACT_MAX_TOTAL_SIZE_MB", "10")
+	t.Setenv("EXTRACT_MAX_ENTRIES", "5")
+	t.Setenv("EXTRACT_MAX_RATIO", "0")
+	limits = MakeExtractionLimits()
+	assert.Equal(t, int64(10*1024*1024), limits.MaxTotalSize)
+	assert.Equal(t, 5, limits.MaxEntries)
+	assert.Equal(t, int64(0), limits.MaxRatio)
+}
+
+func TestUnzipFile_ExtractionLimits(t *testing.T) {
+	entries := []testZipEntry{
+		{name: "Mod/", mode: os.ModeDir | 0755},
+		{name: "Mod/Mod.dll", content: strings.Repeat("a", 100)},
+		{name: "Mod/zeros.bin", content: strings.Repeat("\x00", 1024*1024)},
+	}
+
+	tests := []struct {
+		name      string
+		limits    ExtractionLimits
+		wantLimit string
+	}{
+		{name: "too many entries", limits: ExtractionLimits{MaxEntries: 2}, wantLimit: LimitEntries},
+		{name: "entry too large", limits: ExtractionLimits{MaxEntrySize: 1024}, wantLimit: LimitEntrySize},
+		{name: "total too large", limits: ExtractionLimits{MaxTotalSize: 1024 * 1024}, wantLimit: LimitTotalSize},
+		{name: "compression ratio too high", limits: ExtractionLimits{MaxRatio: 100}, wantLimit: LimitRatio},
+		{name: "within limits", limits: ExtractionLimits{MaxEntries: 3, MaxEntrySize: 1024 * 1024, MaxTotalSize: 2 * 1024 * 1024}},
+		{name: "no limits", limits: ExtractionLimits{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			destDir := t.TempDir()
+			zipPath := filepath.Join(destDir, "Mod.zip")
+			require.NoError(t, os.Rename(createTestZipEntries(t, entries), zipPath))
+
+			a := &Archive{ZipFilePath: zipPath, Destination: destDir, Limits: &tt.limits}
+			err := a.UnzipFile()
+			if tt.wantLimit == "" {
+				require.NoError(t, err)
+				assertFileContent(t, filepath.Join(destDir, "Mod", "Mod.dll"), strings.Repeat("a", 100))
+				return
+			}
+
+			var limitErr *LimitExceededError
+			require.True(t, errors.As(err, &limitErr), "UnzipFile() error = %v, want *LimitExceededError", err)
+			assert.Equal(t, tt.wantLimit, limitErr.Limit)
+			assert.Equal(t, "Mod.zip", limitErr.Archive)
+
+			// Nothing is installed when a limit is crossed
+			_, err = os.Stat(filepath.Join(destDir, "Mod"))
+			assert.True(t, os.IsNotExist(err))
+			_, err = os.Stat(manifestPath(destDir, "Mod.zip"))
+			assert.True(t, os.IsNotExist(err))
+		})
+	}
+}
+
+func TestExtractionMeter_UntrustedSizes(t *testing.T) {
+	limits := &ExtractionLimits{MaxEntrySize: 1024, MaxTotalSize: 4096}
+
+	// An entry which declares itself small but streams far more than that
+	meter := limits.meter("bomb.zip", 10)
+	entry := archiveEntry{Name: "bomb.bin", Mode: 0644, Size: 10}
+	require.NoError(t, meter.checkEntries([]archiveEntry{entry}))
+
+	written, err := io.Copy(io.Discard, meter.reader(entry, bytes.NewReader(make([]byte, 1024*1024))))
+	var limitErr *LimitExceededError
+	require.True(t, errors.As(err, &limitErr), "io.Copy() error = %v, want *LimitExceededError", err)
+	assert.Equal(t, LimitEntrySize, limitErr.Limit)
+	assert.Equal(t, "bomb.bin", limitErr.Entry)
+	assert.Less(t, written, int64(64*1024), "extraction should stop as soon as the limit is crossed")
+
+	// The total is tracked across entries
+	meter = limits.meter("bomb.zip", 10)
+	for i := 0; i < 4; i++ {
+		_, err = io.Copy(io.Discard, meter.reader(entry, bytes.NewReader(make([]byte, 1024))))
+		require.NoError(t, err)
+	}
+	_, err = io.Copy(io.Discard, meter.reader(entry, bytes.NewReader(make([]byte, 1))))
+	require.True(t, errors.As(err, &limitErr))
+	assert.Equal(t, LimitTotalSize, limitErr.Limit)
+	assert.Contains(t, err.Error(), "bomb.zip exceeds the total size limit of 4096")
+}
+
+func TestOpenArchive_TarListingLimits(t *testing.T) {
+	var tiny []testZipEntry
+	for i := 0; i < 1000; i++ {
+		tiny = append(tiny, testZipEntry{name: fmt.Sprintf("Mod/%d.txt", i), content: "a"})
+	}
+	large := []testZipEntry{
+		{name: "Mod/zeros.bin", content: strings.Repeat("\x00", 1024*1024)},
+		{name: "Mod/Mod.dll", content: "dll"},
+	}
+
+	tests := []struct {
+		name      string
+		entries   []testZipEntry
+		limits    ExtractionLimits
+		wantLimit string
+		wantValue int64
+	}{
+		{name: "too many entries", entries: tiny, limits: ExtractionLimits{MaxEntries: 10}, wantLimit: LimitEntries, wantValue: 11},
+		{name: "entry too large", entries: large, limits: ExtractionLimits{MaxEntrySize: 1024}, wantLimit: LimitEntrySize, wantValue: 1024 * 1024},
+		{name: "total too large", entries: tiny, limits: ExtractionLimits{MaxTotalSize: 100}, wantLimit: LimitTotalSize, wantValue: 101},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, format := range []ArchiveFormat{FormatTarGzip, FormatTarZstd} {
+				path := createTestTar(t, format, tt.entries)
+				_, err := openArchive(path, tt.limits.meter(path, 1))
+
+				// Listing stops at the first entry which crosses the limit
+				var limitErr *LimitExceededError
+				require.True(t, errors.As(err, &limitErr), "openArchive() error = %v, want *LimitExceededError", err)
+				assert.Equal(t, tt.wantLimit, limitErr.Limit)
+				assert.Equal(t, tt.wantValue, limitErr.Value)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+const manifestDirName = "manifests"
+
+// InstallManifest Records exactly which files an archive installed so it can be uninstalled reliably even if the
+// archive has since been replaced by a newer version or deleted.
+type InstallManifest struct {
+	Archive     string         `json:"archive"`
+	Package     string         `json:"package,omitempty"` // The name from the archive's manifest.json when it has one
+	Version     string         `json:"version,omitempty"`
+	Root        string         `json:"root"` // The directory file and dir paths are relative to
+	InstalledAt time.Time      `json:"installed_at"`
+	Files       []ManifestFile `json:"files"`
+	Dirs        []string       `json:"dirs"`               // Directories which didn't exist before the archive was installed
+	Disabled    bool           `json:"disabled,omitempty"` // The files have been moved to the disabled directory
+}
+
+type ManifestFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// UninstallReport Describes what happened to each file when an archive was uninstalled.
+type UninstallReport struct {
+	Removed  []string
+	Missing  []string // Files which had already been removed
+	Modified []string // Files which changed since install and were left in place
+}
+
+// manifestPath Returns the path of the manifest for the archive installed into destination.
+func manifestPath(destination, archive string) string {
+	return filepath.Join(destination, workDirName, manifestDirName, filepath.Base(archive)+".json")
+}
+
+// readManifest Reads an install manifest returning os.ErrNotExist (wrapped) when the archive was installed before
+// manifests were written.
+func readManifest(path string) (*InstallManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest InstallManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest %s: %w", path, err)
+	}
+	return &manifest, nil
+}
+
+// writeManifest Atomically writes the manifest to path.
+func writeManifest(path string, manifest *InstallManifest) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = writeFileAtomic(path, func(file *os.File) (int64, error) {
+		n, err := file.Write(data)
+		return int64(n), err
+	})
+	return err
+}
+
+// mergeDirs Combines the directories created by this install with those recorded by a previous install of the same
+// archive. Reinstalling a mod doesn't recreate its directories so without this they would be forgotten and left
+// behind on uninstall.
+func mergeDirs(root string, created []string, previous *InstallManifest) []string {
+	dirs := slices.Clone(created)
+	if previous != nil {
+		for _, dir := range previous.Dirs {
+			if info, err := os.Stat(filepath.Join(root, dir)); err == nil && info.IsDir() && !slices.Contains(dirs, dir) {
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// dropPreviousFiles Removes the files recorded by a previous install of the archive which the new install no longer
+// ships. Without this they would be left behind untracked when a mod is upgraded to a version which drops a file.
+// Files which changed since the previous install are left in place and carried into the new manifest so uninstalling
+// still reports them.
+func dropPreviousFiles(tx *installTransaction, manifest, previous *InstallManifest) error {
+	// A disabled install's files live in the disabled directory which is removed as a whole
+	if previous == nil || previous.Disabled {
+		return nil
+	}
+
+	installed := make(map[string]bool, len(manifest.Files))
+	for _, file := range manifest.Files {
+		installed[filepath.Join(manifest.Root, filepath.FromSlash(file.Path))] = true
+	}
+
+	for _, file := range previous.Files {
+		path := filepath.Join(previous.Root, filepath.FromSlash(file.Path))
+		if installed[path] {
+			continue
+		}
+
+		sum, err := hashFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if sum == file.SHA256 {
+			log.Infof("removing file %s which is no longer part of %s", path, manifest.Archive)
+			if err := tx.remove(path); err != nil {
+				return err
+			}
+			continue
+		}
+
+		rel, err := filepath.Rel(manifest.Root, path)
+		if err != nil || !filepath.IsLocal(rel) {
+			log.Warnf("file %s has changed since it was installed and is outside %s, no longer tracking it", path, manifest.Root)
+			continue
+		}
+		log.Warnf("file %s has changed since it was installed, leaving it in place", path)
+		manifest.Files = append(manifest.Files, ManifestFile{Path: filepath.ToSlash(rel), Size: file.Size, SHA256: file.SHA256})
+	}
+	return nil
+}
+
+// hashFile Returns the hex encoded SHA-256 of the file at path.
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// uninstallManifest Removes every file recorded in the manifest which is unchanged since install, then removes the
+// directories the install created if they are empty. Files whose contents changed since install are left in place and
+// reported since they may belong to the user or another mod now.
+func uninstallManifest(manifest *InstallManifest) (*UninstallReport, error) {
+	report := &UninstallReport{}
+	for _, file := range manifest.Files {
+		path := filepath.Join(manifest.Root, filepath.FromSlash(file.Path))
+		sum, err := hashFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			report.Missing = append(report.Missing, file.Path)
+			continue
+		}
+		if err != nil {
+			return report, err
+		}
+
+		if sum != file.SHA256 {
+			log.Warnf("file %s has changed since it was installed, leaving it in place", path)
+			report.Modified = append(report.Modified, file.Path)
+			continue
+		}
+
+		log.Infof("removing file %s", path)
+		if err := os.Remove(path); err != nil {
+			return report, err
+		}
+		report.Removed = append(report.Removed, file.Path)
+	}
+
+	removeEmptyDirs(manifest.Root, manifest.Dirs)
+	return report, nil
+}
+
+// removeEmptyDirs Removes each of the directories relative to root which are empty.
+func removeEmptyDirs(root string, dirs []string) {
+	// Deepest directories first so parents are empty by the time they're removed
+	dirs = slices.Clone(dirs)
+	sort.Slice(dirs, func(i, j int) bool {
+		return strings.Count(dirs[i], "/") > strings.Count(dirs[j], "/")
+	})
+	for _, dir := range dirs {
+		path := filepath.Join(root, filepath.FromSlash(dir))
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Infof("leaving non-empty directory %s in place", path)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// installTestZip Creates a zip at destination/name from files and unpacks it.
+func installTestZip(t *testing.T, destDir, name string, files map[string]string) *Archive {
+	t.Helper()
+	tmpZip := createTestZip(t, files)
+	zipPath := filepath.Join(destDir, name)
+	require.NoError(t, os.Rename(tmpZip, zipPath))
+
+	a := &Archive{ZipFilePath: zipPath, Destination: destDir}
+	require.NoError(t, a.UnzipFile())
+	return a
+}
+
+func TestUnzipFile_WritesManifest(t *testing.T) {
+	destDir := t.TempDir()
+	createTestFiles(t, map[string]string{"Existing/keep.txt": "keep"}, destDir)
+
+	installTestZip(t, destDir, "Mod.zip", map[string]string{
+		"Mod/Mod.dll":       "dll",
+		"Mod/Assets/a.png":  "png",
+		"Existing/Mod.json": "json",
+	})
+
+	manifest, err := readManifest(manifestPath(destDir, "Mod.zip"))
+	require.NoError(t, err)
+	assert.Equal(t, "Mod.zip", manifest.Archive)
+	assert.Equal(t, filepath.Clean(destDir), manifest.Root)
+	assert.ElementsMatch(t, []ManifestFile{
+		{Path: "Mod/Mod.dll", Size: 3, SHA256: mustHash(t, "dll")},
+		{Path: "Mod/Assets/a.png", Size: 3, SHA256: mustHash(t, "png")},
+		{Path: "Existing/Mod.json", Size: 4, SHA256: mustHash(t, "json")},
+	}, manifest.Files)
+	// Existing was already there so it isn't owned by the mod
+	assert.Equal(t, []string{"Mod", "Mod/Assets"}, manifest.Dirs)
+}
+
+func TestRemoveFilesFromZip_UsesManifest(t *testing.T) {
+	tests := []struct {
+		name  string
+		after func(t *testing.T, a *Archive)
+	}{
+		{
+			name: "zip deleted",
+			after: func(t *testing.T, a *Archive) {
+				require.NoError(t, os.Remove(a.ZipFilePath))
+			},
+		},
+		{
+			name: "zip replaced by a newer version",
+			after: func(t *testing.T, a *Archive) {
+				newer := createTestZip(t, map[string]string{"Renamed/Renamed.dll": "v2"})
+				require.NoError(t, os.Rename(newer, a.ZipFilePath))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			destDir := t.TempDir()
+			createTestFiles(t, map[string]string{"Other/Other.dll": "other mod"}, destDir)
+
+			a := installTestZip(t, destDir, "Mod.zip", map[string]string{
+				"Mod/Mod.dll":      "dll",
+				"Mod/Assets/a.png": "png",
+				"Other/Mod.cfg":    "cfg",
+			})
+			tt.after(t, a)
+
+			report, err := a.RemoveFilesFromZip()
+			require.NoError(t, err)
+			assert.ElementsMatch(t, []string{"Mod/Mod.dll", "Mod/Assets/a.png", "Other/Mod.cfg"}, report.Removed)
+			assert.Empty(t, report.Modified)
+
+			_, err = os.Stat(filepath.Join(destDir, "Mod"))
+			assert.True(t, os.IsNotExist(err), "directories created by the mod should be removed")
+			assertFileContent(t, filepath.Join(destDir, "Other/Other.dll"), "other mod")
+
+			_, err = os.Stat(a.ZipFilePath)
+			assert.True(t, os.IsNotExist(err))
+			_, err = os.Stat(filepath.Join(destDir, workDirName))
+			assert.True(t, os.IsNotExist(err))
+		})
+	}
+}
+
+func TestRemoveFilesFromZip_ReportsModifiedFiles(t *testing.T) {
+	destDir := t.TempDir()
+	a := installTestZip(t, destDir, "Mod.zip", map[string]string{
+		"Mod/Mod.dll":  "dll",
+		"Mod/Mod.cfg":  "cfg",
+		"Mod/Gone.txt": "gone",
+	})
+
+	require.NoError(t, os.WriteFile(filepath.Join(destDir, "Mod/Mod.cfg"), []byte("user edited"), 0644))
+	require.NoError(t, os.Remove(filepath.Join(destDir, "Mod/Gone.txt")))
+
+	report, err := a.RemoveFilesFromZip()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Mod/Mod.dll"}, report.Removed)
+	assert.Equal(t, []string{"Mod/Mod.cfg"}, report.Modified)
+	assert.Equal(t, []string{"Mod/Gone.txt"}, report.Missing)
+
+	// The modified file and so its directory are left in place
+	assertFileContent(t, filepath.Join(destDir, "Mod/Mod.cfg"), "user edited")
+}
+
+func TestUnzipFile_ReinstallKeepsCreatedDirs(t *testing.T) {
+	destDir := t.TempDir()
+	installTestZip(t, destDir, "Mod.zip", map[string]string{"Mod/Mod.dll": "v1"})
+	a := installTestZip(t, destDir, "Mod.zip", map[string]string{"Mod/Mod.dll": "v2"})
+
+	manifest, err := readManifest(manifestPath(destDir, "Mod.zip"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Mod"}, manifest.Dirs)
+
+	_, err = a.RemoveFilesFromZip()
+	require.NoError(t, err)
+	_, err = os.Stat(filepath.Join(destDir, "Mod"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestUnzipFile_UpgradeRemovesDroppedFiles(t *testing.T) {
+	destDir := t.TempDir()
+	installTestZip(t, destDir, "Mod.zip", map[string]string{
+		"Mod/Mod.dll":     "v1",
+		"Mod/Legacy.dll":  "legacy",
+		"Mod/Legacy.cfg":  "cfg",
+		"Mod/Old/old.txt": "old",
+	})
+	require.NoError(t, os.WriteFile(filepath.Join(destDir, "Mod/Legacy.cfg"), []byte("user edited"), 0644))
+
+	a := installTestZip(t, destDir, "Mod.zip", map[string]string{"Mod/Mod.dll": "v2"})
+
+	_, err := os.Stat(filepath.Join(destDir, "Mod/Legacy.dll"))
+	assert.True(t, os.IsNotExist(err), "files dropped by the new version should be removed")
+	_, err = os.Stat(filepath.Join(destDir, "Mod/Old/old.txt"))
+	assert.True(t, os.IsNotExist(err))
+	assertFileContent(t, filepath.Join(destDir, "Mod/Legacy.cfg"), "user edited")
+
+	manifest, err := readManifest(manifestPath(destDir, "Mod.zip"))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []ManifestFile{
+		{Path: "Mod/Mod.dll", Size: 2, SHA256: mustHash(t, "v2")},
+		{Path: "Mod/Legacy.cfg", Size: 3, SHA256: mustHash(t, "cfg")},
+	}, manifest.Files)
+
+	report, err := a.RemoveFilesFromZip()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Mod/Mod.dll"}, report.Removed)
+	assert.Equal(t, []string{"Mod/Legacy.cfg"}, report.Modified)
+
+	_, err = os.Stat(filepath.Join(destDir, "Mod/Legacy.dll"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(destDir, "Mod/Old"))
+	assert.True(t, os.IsNotExist(err), "directories emptied by the upgrade should be removed on uninstall")
+	assertFileContent(t, filepath.Join(destDir, "Mod/Legacy.cfg"), "user edited")
+}
+
+func mustHash(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "hash")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	sum, err := hashFile(path)
+	require.NoError(t, err)
+	return sum
+}
@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// maxPackageMetadataSize The largest manifest.json or icon.png read from a package. Thunderstore icons are 256x256 PNGs
+// so anything larger isn't package metadata.
+const maxPackageMetadataSize = 1024 * 1024
+
+// PackageManifest The manifest.json every Thunderstore package is published with.
+type PackageManifest struct {
+	Name          string   `json:"name"`
+	VersionNumber string   `json:"version_number"`
+	WebsiteUrl    string   `json:"website_url"`
+	Description   string   `json:"description"`
+	Dependencies  []string `json:"dependencies"` // Dependency strings of the form Author-Name-1.0.0
+}
+
+// PackageMetadata The metadata bundled with a mod describing what it is and who made it.
+type PackageMetadata struct {
+	PackageManifest
+	Author string // The Thunderstore team which published the package when the archive is named Author-Name-Version
+	Icon   []byte // The contents of icon.png or nil when the package has no icon
+}
+
+// Creator Returns who made the package. Thunderstore manifests don't include the author so the team name from the
+// archive's name is used when available, falling back to the package's website.
+func (m *PackageMetadata) Creator() string {
+	if m.Author != "" {
+		return m.Author
+	}
+	return m.WebsiteUrl
+}
+
+// ReadPackageMetadata Reads the manifest.json and icon.png from the root of the package in the archive, looking inside
+// any wrapper folder the package was zipped with. Nil is returned when the archive has no manifest.json since plenty of
+// mods aren't packaged for Thunderstore.
+func (a *Archive) ReadPackageMetadata() (*PackageMetadata, error) {
+	reader, err := a.open()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	entries := reader.Entries()
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = strings.ReplaceAll(entry.Name, `\`, "/")
+	}
+	wrapper := thunderstoreWrapper(names)
+
+	var manifest, icon []byte
+	i := 0
+	err = reader.Walk(func(entry archiveEntry, r io.Reader) error {
+		name := strings.TrimPrefix(names[i], wrapper)
+		i++
+		if entry.IsDir() {
+			return nil
+		}
+
+		var err error
+		switch {
+		case strings.EqualFold(name, "manifest.json"):
+			manifest, err = readPackageFile(entry, r)
+		case strings.EqualFold(name, "icon.png"):
+			icon, err = readPackageFile(entry, r)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if manifest == nil {
+		return nil, nil
+	}
+
+	metadata := &PackageMetadata{Icon: icon}
+
+	// Manifests written on Windows often start with a byte order mark which encoding/json rejects
+	if err := json.Unmarshal(bytes.TrimPrefix(manifest, []byte("\xef\xbb\xbf")), &metadata.PackageManifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest.json in %s: %w", a.ZipFilePath, err)
+	}
+
+	if metadata.Name != "" {
+		if i := strings.Index(packageName(a.ZipFilePath), "-"+metadata.Name); i > 0 {
+			metadata.Author = packageName(a.ZipFilePath)[:i]
+		}
+	}
+	return metadata, nil
+}
+
+// readPackageFile Reads a small metadata file from the package.
+func readPackageFile(entry archiveEntry, r io.Reader) ([]byte, error) {
+	if entry.Size > maxPackageMetadataSize {
+		return nil, fmt.Errorf("%s is %d bytes, larger than the %d allowed for package metadata", entry.Name, entry.Size, maxPackageMetadataSize)
+	}
+	return io.ReadAll(io.LimitReader(r, maxPackageMetadataSize))
+}
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testPackageManifest = `{
+	"name": "ValheimPlus",
+	"version_number": "0.9.9",
+	"website_url": "https://github.com/valheimPlus/ValheimPlus",
+	"description": "A HarmonyX mod aimed at improving the gameplay and quality of life of Valheim.",
+	"dependencies": ["denikson-BepInExPack_Valheim-5.4.2202"]
+}`
+
+func TestReadPackageMetadata(t *testing.T) {
+	tests := []struct {
+		name        string
+		archiveName string
+		entries     []testZipEntry
+		want        *PackageMetadata
+		wantErr     bool
+	}{
+		{
+			name:        "thunderstore package",
+			archiveName: "Grantapher-ValheimPlus-0.9.9.zip",
+			entries: []testZipEntry{
+				{name: "manifest.json", content: testPackageManifest},
+				{name: "icon.png", content: "png"},
+				{name: "plugins/ValheimPlus.dll", content: "dll"},
+			},
+			want: &PackageMetadata{
+				PackageManifest: PackageManifest{
+					Name:          "ValheimPlus",
+					VersionNumber: "0.9.9",
+					WebsiteUrl:    "https://github.com/valheimPlus/ValheimPlus",
+					Description:   "A HarmonyX mod aimed at improving the gameplay and quality of life of Valheim.",
+					Dependencies:  []string{"denikson-BepInExPack_Valheim-5.4.2202"},
+				},
+				Author: "Grantapher",
+				Icon:   []byte("png"),
+			},
+		},
+		{
+			name:        "wrapper folder, byte order mark and no icon",
+			archiveName: "ValheimPlus.zip",
+			entries: []testZipEntry{
+				{name: "ValheimPlus/Manifest.json", content: "\xef\xbb\xbf" + testPackageManifest},
+				{name: "ValheimPlus/plugins/ValheimPlus.dll", content: "dll"},
+				{name: "ValheimPlus/plugins/icon.png", content: "not the package icon"},
+			},
+			want: &PackageMetadata{
+				PackageManifest: PackageManifest{
+					Name:          "ValheimPlus",
+					VersionNumber: "0.9.9",
+					WebsiteUrl:    "https://github.com/valheimPlus/ValheimPlus",
+					Description:   "A HarmonyX mod aimed at improving the gameplay and quality of life of Valheim.",
+					Dependencies:  []string{"denikson-BepInExPack_Valheim-5.4.2202"},
+				},
+			},
+		},
+		{
+			name:        "no manifest",
+			archiveName: "Mod.zip",
+			entries:     []testZipEntry{{name: "Mod.dll", content: "dll"}},
+		},
+		{
+			name:        "invalid manifest",
+			archiveName: "Mod.zip",
+			entries:     []testZipEntry{{name: "manifest.json", content: "{"}},
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zipPath := filepath.Join(t.TempDir(), tt.archiveName)
+			require.NoError(t, os.Rename(createTestZipEntries(t, tt.entries), zipPath))
+
+			a := &Archive{ZipFilePath: zipPath, Destination: t.TempDir()}
+			got, err := a.ReadPackageMetadata()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestReadPackageMetadata_Tarball(t *testing.T) {
+	tarPath := createTestTar(t, FormatTarGzip, []testZipEntry{
+		{name: "manifest.json", content: testPackageManifest},
+		{name: "icon.png", content: "png"},
+	})
+
+	a := &Archive{ZipFilePath: tarPath, Destination: t.TempDir()}
+	got, err := a.ReadPackageMetadata()
+	require.NoError(t, err)
+	assert.Equal(t, "0.9.9", got.VersionNumber)
+	assert.Equal(t, []byte("png"), got.Icon)
+}
+
+func TestPackageMetadata_Creator(t *testing.T) {
+	metadata := &PackageMetadata{PackageManifest: PackageManifest{WebsiteUrl: "https://example.com"}}
+	assert.Equal(t, "https://example.com", metadata.Creator())
+
+	metadata.Author = "Grantapher"
+	assert.Equal(t, "Grantapher", metadata.Creator())
+}
@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	amqp "github.com/rabbitmq/amqp091-go"
@@ -9,34 +10,84 @@ import (
 )
 
 type RabbitMQService struct {
-	Channel *amqp.Channel
+	Channel       *amqp.Channel
+	LegacyContent bool // Also publish the payload as a pre-encoded json string in "content" for existing consumers
 }
 
-func MakeRabbitMQService() (*RabbitMQService, error) {
+// MakeRabbitMQService Connects to RabbitMQ and opens a channel. Connection failures are retried according to the
+// given retry policy. Legacy "content" fields are published unless RABBITMQ_LEGACY_CONTENT is set to false.
+func MakeRabbitMQService(retry *RetryPolicy) (*RabbitMQService, error) {
 	credentials := fmt.Sprintf("%s:%s", os.Getenv("RABBITMQ_DEFAULT_USER"), os.Getenv("RABBITMQ_DEFAULT_PASS"))
-	conn, err := amqp.Dial(fmt.Sprintf("amqp://%s@%s/", credentials, os.Getenv("RABBITMQ_BASE_URL")))
-	if err != nil {
-		log.Errorf("failed to connect to RabbitMQ: %v", err)
-		return nil, err
-	}
 
-	ch, err := conn.Channel()
+	var ch *amqp.Channel
+	err := retry.Do(context.Background(), "connect to rabbitmq", func() error {
+		conn, err := amqp.Dial(fmt.Sprintf("amqp://%s@%s/", credentials, os.Getenv("RABBITMQ_BASE_URL")))
+		if err != nil {
+			log.Errorf("failed to connect to RabbitMQ: %v", err)
+			return err
+		}
+
+		ch, err = conn.Channel()
+		if err != nil {
+			log.Errorf("failed to open a channel: %v", err)
+			conn.Close()
+			return err
+		}
+		return nil
+	})
 	if err != nil {
-		log.Errorf("failed to open a channel: %v", err)
 		return nil, err
 	}
 
 	return &RabbitMQService{
-		Channel: ch,
+		Channel:       ch,
+		LegacyContent: os.Getenv("RABBITMQ_LEGACY_CONTENT") != "false",
 	}, nil
 }
 
 type Message struct {
-	Type      string `json:"type"`
-	Body      string `json:"content"` // It is expected that Body is pre-encoded json string i.e. "{\"content\": \"foo\"}"
-	DiscordId string `json:"discord_id"`
+	Type      string          `json:"type"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+	Body      string          `json:"content,omitempty"` // Legacy copy of the payload as a pre-encoded json string i.e. "{\"content\": \"foo\"}"
+	DiscordId string          `json:"discord_id"`
+}
+
+// makeMessage Wraps a typed event in a message. In legacy mode the payload is also encoded into the "content" string
+// and events which replaced an older message keep the older type name so existing consumers continue to work.
+func (rm *RabbitMQService) makeMessage(discordId string, event Event) (*Message, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+
+	message := &Message{
+		Type:      event.EventType(),
+		Payload:   payload,
+		DiscordId: discordId,
+	}
+
+	if rm.LegacyContent {
+		message.Body = string(payload)
+		if legacyType, ok := legacyEventTypes[message.Type]; ok {
+			message.Type = legacyType
+		}
+	}
+
+	return message, nil
 }
 
+// PublishEvent Publishes a typed event routed by the user's discord id.
+func (rm *RabbitMQService) PublishEvent(discordId string, event Event) error {
+	message, err := rm.makeMessage(discordId, event)
+	if err != nil {
+		log.Errorf("failed to marshal %s event: %v", event.EventType(), err)
+		return err
+	}
+	return rm.PublishMessage(message)
+}
+
+// PublishMessage Publishes a message to the valheim-server-status exchange routed by the user's discord id. The
+// channel is left open so multiple messages can be published; call Close once the Job is done publishing.
 func (rm *RabbitMQService) PublishMessage(message *Message) error {
 	messageBytes, err := json.Marshal(message)
 	if err != nil {
@@ -44,8 +95,6 @@ func (rm *RabbitMQService) PublishMessage(message *Message) error {
 		return err
 	}
 
-	defer rm.Channel.Close()
-
 	return rm.Channel.Publish(
 		"valheim-server-status", // exchange
 		message.DiscordId,       // routing key
@@ -57,3 +106,8 @@ func (rm *RabbitMQService) PublishMessage(message *Message) error {
 		},
 	)
 }
+
+// Close Closes the channel used to publish messages.
+func (rm *RabbitMQService) Close() error {
+	return rm.Channel.Close()
+}
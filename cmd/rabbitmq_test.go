@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestMakeMessage(t *testing.T) {
+	t.Setenv("HOSTNAME", "file-manager-abc")
+	fileManager := &FileManager{
+		DiscordId: "123",
+		Op:        WRITE,
+		Prefix:    "mods/general/Valheim\"Plus.zip",
+	}
+
+	failed := &InstallFailed{
+		EventMetadata: MakeEventMetadata(fileManager),
+		Stage:         "download",
+		Error:         `failed to get object "mods/general/ValheimPlus.zip"`,
+	}
+
+	tests := []struct {
+		name        string
+		legacy      bool
+		event       Event
+		wantType    string
+		wantContent bool
+	}{
+		{name: "typed failure", legacy: false, event: failed, wantType: InstallFailedType},
+		{name: "legacy failure", legacy: true, event: failed, wantType: "Failure", wantContent: true},
+		{name: "legacy success keeps PreStop type", legacy: true, event: &InstallSucceeded{EventMetadata: MakeEventMetadata(fileManager)}, wantType: "PreStop", wantContent: true},
+		{name: "legacy progress has no legacy type", legacy: true, event: &InstallProgress{EventMetadata: MakeEventMetadata(fileManager)}, wantType: InstallProgressType, wantContent: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rabbit := &RabbitMQService{LegacyContent: tt.legacy}
+			message, err := rabbit.makeMessage(fileManager.DiscordId, tt.event)
+			require.NoError(t, err)
+
+			// Round trip through the wire format as a consumer would see it
+			wire, err := json.Marshal(message)
+			require.NoError(t, err)
+
+			var decoded map[string]any
+			require.NoError(t, json.Unmarshal(wire, &decoded))
+			assert.Equal(t, tt.wantType, decoded["type"])
+			assert.Equal(t, "123", decoded["discord_id"])
+
+			payload := decoded["payload"].(map[string]any)
+			assert.Equal(t, float64(EventSchemaVersion), payload["schemaVersion"])
+			assert.Equal(t, "file-manager-abc", payload["containerName"])
+			assert.Equal(t, "file-install", payload["containerType"])
+			assert.Equal(t, `mods/general/Valheim"Plus.zip`, payload["prefix"])
+
+			content, ok := decoded["content"].(string)
+			assert.Equal(t, tt.wantContent, ok)
+			if tt.wantContent {
+				var legacy map[string]any
+				require.NoError(t, json.Unmarshal([]byte(content), &legacy))
+				assert.Equal(t, payload, legacy)
+			}
+		})
+	}
+}
+
+func TestInstallFailedPayload(t *testing.T) {
+	event := &InstallFailed{
+		EventMetadata: MakeEventMetadata(&FileManager{Op: DELETE, Prefix: "mods/mod.zip"}),
+		Stage:         "operation",
+		Error:         "boom",
+	}
+
+	payload, err := json.Marshal(event)
+	require.NoError(t, err)
+
+	var decoded InstallFailed
+	require.NoError(t, json.Unmarshal(payload, &decoded))
+	assert.Equal(t, *event, decoded)
+	assert.Equal(t, DELETE, decoded.Operation)
+}
+
+func TestInstallSucceededPayload(t *testing.T) {
+	event := &InstallSucceeded{
+		EventMetadata: MakeEventMetadata(&FileManager{Op: WRITE, Prefix: "mods/Mod.zip"}),
+		SHA256:        "abc",
+		Verified:      "ETag",
+		Package:       "Mod",
+		Version:       "1.0.0",
+		Dependencies:  []string{"Author-Core-1.0.0"},
+		World:         &WorldMetadata{Version: 34, Name: "MyWorld", SeedName: "seed"},
+	}
+
+	payload, err := json.Marshal(event)
+	require.NoError(t, err)
+
+	var decoded map[string]any
+	require.NoError(t, json.Unmarshal(payload, &decoded))
+	assert.Equal(t, "ETag", decoded["verified"])
+	assert.Equal(t, []any{"Author-Core-1.0.0"}, decoded["dependencies"])
+	assert.Equal(t, "MyWorld", decoded["world"].(map[string]any)["name"])
+}
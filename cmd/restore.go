@@ -0,0 +1,249 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// BackupTimestampLayout The layout of the timestamp in a world backup's file name which is the same one Valheim uses
+	// for its auto backups.
+	BackupTimestampLayout = "20060102150405"
+
+	// BackupLabelAuto Labels the backups Valheim makes of a world while the server runs.
+	BackupLabelAuto = "auto"
+
+	// BackupLabelRestore Labels the snapshot of a world taken before a backup is restored over it.
+	BackupLabelRestore = "restore"
+)
+
+// worldBackupPattern Matches the file names of world backups i.e. MyWorld_backup_auto-20250101120000.db
+var worldBackupPattern = regexp.MustCompile(`^(.+)_backup_([A-Za-z0-9]+)-(\d{14})\.(db|fwl)$`)
+
+// WorldBackup A .db or .fwl file backing up a world.
+type WorldBackup struct {
+	World     string // The name of the world backed up i.e. MyWorld
+	Label     string // What made the backup i.e. auto for Valheim's auto backups
+	Timestamp string // When the backup was made formatted with BackupTimestampLayout
+	Time      time.Time
+	Ext       string // .db or .fwl
+}
+
+// ParseWorldBackup Parses the file name of a world backup returning nil when the file isn't one.
+func ParseWorldBackup(fileName string) *WorldBackup {
+	match := worldBackupPattern.FindStringSubmatch(fileName)
+	if match == nil {
+		return nil
+	}
+
+	t, err := time.ParseInLocation(BackupTimestampLayout, match[3], time.Local)
+	if err != nil {
+		return nil
+	}
+
+	return &WorldBackup{World: match[1], Label: match[2], Timestamp: match[3], Time: t, Ext: "." + match[4]}
+}
+
+// IsWorldBackup Returns true when the file is a backup of a world rather than the world itself.
+func IsWorldBackup(fileName string) bool {
+	return ParseWorldBackup(fileName) != nil
+}
+
+// WorldBackupName Returns the file name of a world's backup i.e. MyWorld_backup_auto-20250101120000.db
+func WorldBackupName(world, label, timestamp, ext string) string {
+	return fmt.Sprintf("%s_backup_%s-%s%s", world, label, timestamp, ext)
+}
+
+// validateWorldName Checks a world name names a file directly within the backups directory.
+func validateWorldName(world string) error {
+	if world == "" || world == "." || world == ".." || strings.ContainsAny(world, `/\`) {
+		return fmt.Errorf("invalid world name %q", world)
+	}
+	return nil
+}
+
+// RestoreResult Describes a world restored from a backup.
+type RestoreResult struct {
+	World    string
+	Backup   []string // The backup files restored
+	Snapshot []string // The snapshot taken of the world before it was replaced, empty when there was no world
+	Bytes    int64
+}
+
+// Restore Replaces a world with its backup from the given timestamp. Both the .db and .fwl file of the backup must
+// exist. The world's current files are first copied to a snapshot labelled "restore" so the restore can itself be
+// undone, then the backup is copied over the world's canonical file names. The backup is left in place. Each file is
+// replaced atomically and the world is put back from the snapshot if the pair can't be replaced together.
+func (f *FileManager) Restore() (*RestoreResult, error) {
+	if err := validateWorldName(f.World); err != nil {
+		return nil, err
+	}
+
+	backup, err := findWorldBackup(f.World, f.Timestamp)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &RestoreResult{World: f.World}
+	snapshot, err := snapshotWorld(BACKUPS_DIR, f.World, BackupLabelRestore)
+	if err != nil {
+		return nil, fmt.Errorf("failed to snapshot world %s before restoring it: %w", f.World, err)
+	}
+
+	if len(snapshot) == 0 {
+		log.Infof("world %s doesn't exist yet, nothing to snapshot before restoring it", f.World)
+	}
+	for _, ext := range []string{".db", ".fwl"} {
+		if snapshot[ext] != "" {
+			result.Snapshot = append(result.Snapshot, filepath.Base(snapshot[ext]))
+		}
+	}
+
+	var restored []string
+	for _, ext := range []string{".db", ".fwl"} {
+		current := filepath.Join(BACKUPS_DIR, f.World+ext)
+		written, err := copyFile(backup[ext], current)
+		if err != nil {
+			err = fmt.Errorf("failed to restore %s from %s: %w", current, backup[ext], err)
+			if revertErr := revertWorld(BACKUPS_DIR, f.World, restored, snapshot); revertErr != nil {
+				return nil, fmt.Errorf("%w (%v)", err, revertErr)
+			}
+			return nil, err
+		}
+
+		restored = append(restored, ext)
+		result.Backup = append(result.Backup, filepath.Base(backup[ext]))
+		result.Bytes += written
+	}
+
+	log.Infof("restored world %s from %v", f.World, result.Backup)
+	return result, nil
+}
+
+// findWorldBackup Returns the paths of the .db and .fwl files of a world's backup keyed by extension. Backups are
+// matched by timestamp whatever their label so the snapshots taken before a restore or write can be restored too.
+func findWorldBackup(world, timestamp string) (map[string]string, error) {
+	files, err := os.ReadDir(BACKUPS_DIR)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backups: %w", err)
+	}
+
+	byLabel := map[string]map[string]string{}
+	for _, file := range files {
+		backup := ParseWorldBackup(file.Name())
+		if file.IsDir() || backup == nil || backup.World != world || backup.Timestamp != timestamp {
+			continue
+		}
+
+		if byLabel[backup.Label] == nil {
+			byLabel[backup.Label] = map[string]string{}
+		}
+		byLabel[backup.Label][backup.Ext] = filepath.Join(BACKUPS_DIR, file.Name())
+	}
+
+	if len(byLabel) == 0 {
+		return nil, fmt.Errorf("no backup of world %s from %s found in %s", world, timestamp, BACKUPS_DIR)
+	}
+
+	labels := slices.Sorted(maps.Keys(byLabel))
+	if len(labels) > 1 {
+		return nil, fmt.Errorf("found more than one backup of world %s from %s: %s", world, timestamp, strings.Join(labels, ", "))
+	}
+
+	backup := byLabel[labels[0]]
+	for _, ext := range []string{".db", ".fwl"} {
+		if _, ok := backup[ext]; !ok {
+			return nil, fmt.Errorf("backup %s is missing its %s file", WorldBackupName(world, labels[0], timestamp, ""), ext)
+		}
+	}
+	return backup, nil
+}
+
+// snapshotWorld Copies the .db and .fwl files of a world in dir to a backup with the given label returning the paths of
+// the copies keyed by extension. Files the world doesn't have are skipped. The snapshot is taken at the current time,
+// or the first second after it which no backup of the world has been taken at, and is removed again if either file
+// can't be copied.
+func snapshotWorld(dir, world, label string) (map[string]string, error) {
+	snapshot := map[string]string{}
+	timestamp := unusedBackupTimestamp(dir, world, time.Now())
+	for _, ext := range []string{".db", ".fwl"} {
+		current := filepath.Join(dir, world+ext)
+		if _, err := os.Stat(current); os.IsNotExist(err) {
+			continue
+		}
+
+		path := filepath.Join(dir, WorldBackupName(world, label, timestamp, ext))
+		if _, err := copyFile(current, path); err != nil {
+			for _, copied := range snapshot {
+				os.Remove(copied)
+			}
+			return nil, fmt.Errorf("failed to copy %s: %w", current, err)
+		}
+		snapshot[ext] = path
+	}
+
+	if len(snapshot) > 0 {
+		log.Infof("snapshotted world %s to %s", world, WorldBackupName(world, label, timestamp, ""))
+	}
+	return snapshot, nil
+}
+
+// unusedBackupTimestamp Returns the timestamp of t or the first second after it which no backup of the world in dir,
+// whatever its label, has been taken at so an earlier backup is never overwritten and a restore is never ambiguous.
+func unusedBackupTimestamp(dir, world string, t time.Time) string {
+	taken := map[string]bool{}
+	if files, err := os.ReadDir(dir); err == nil {
+		for _, file := range files {
+			if backup := ParseWorldBackup(file.Name()); backup != nil && backup.World == world {
+				taken[backup.Timestamp] = true
+			}
+		}
+	}
+
+	for taken[t.Format(BackupTimestampLayout)] {
+		t = t.Add(time.Second)
+	}
+	return t.Format(BackupTimestampLayout)
+}
+
+// revertWorld Puts back the files of a world in dir with the given extensions from a snapshot keyed by extension.
+// Files the snapshot doesn't have, because the world didn't have them, are removed.
+func revertWorld(dir, world string, exts []string, snapshot map[string]string) error {
+	var errs []error
+	for _, ext := range exts {
+		current := filepath.Join(dir, world+ext)
+		var err error
+		if snapshot[ext] != "" {
+			_, err = copyFile(snapshot[ext], current)
+		} else if err = os.Remove(current); os.IsNotExist(err) {
+			err = nil
+		}
+
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to put back %s: %w", current, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// copyFile Copies src over dst atomically returning the number of bytes copied.
+func copyFile(src, dst string) (int64, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	return writeFileAtomic(dst, func(file *os.File) (int64, error) {
+		return io.Copy(file, in)
+	})
+}
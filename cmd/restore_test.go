@@ -0,0 +1,189 @@
+package cmd
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// makeTestWorlds Points the backups directory at a temp directory containing the given files.
+func makeTestWorlds(t *testing.T, files map[string]string) {
+	backupsDir := BACKUPS_DIR
+	t.Cleanup(func() {
+		BACKUPS_DIR = backupsDir
+	})
+	BACKUPS_DIR = t.TempDir()
+
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(BACKUPS_DIR, name), []byte(content), 0644))
+	}
+}
+
+func readTestWorld(t *testing.T, name string) string {
+	data, err := os.ReadFile(filepath.Join(BACKUPS_DIR, name))
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestParseWorldBackup(t *testing.T) {
+	backup := ParseWorldBackup("My_World_backup_auto-20250102030405.fwl")
+	require.NotNil(t, backup)
+	assert.Equal(t, "My_World", backup.World)
+	assert.Equal(t, BackupLabelAuto, backup.Label)
+	assert.Equal(t, "20250102030405", backup.Timestamp)
+	assert.Equal(t, time.Date(2025, 1, 2, 3, 4, 5, 0, time.Local), backup.Time)
+	assert.Equal(t, ".fwl", backup.Ext)
+	assert.Equal(t, "My_World_backup_auto-20250102030405.fwl", WorldBackupName(backup.World, backup.Label, backup.Timestamp, backup.Ext))
+
+	for _, name := range []string{"MyWorld.db", "MyWorld_backup_auto-2025.db", "MyWorld_backup_auto-20251399000000.db", "MyWorld_backup_auto-20250102030405.db.old"} {
+		assert.False(t, IsWorldBackup(name), name)
+	}
+	assert.True(t, IsWorldBackup("MyWorld_backup_restore-20250102030405.db"))
+}
+
+func TestRestore(t *testing.T) {
+	makeTestWorlds(t, map[string]string{
+		"MyWorld.db":                             "current db",
+		"MyWorld.fwl":                            "current fwl",
+		"MyWorld_backup_auto-20250101120000.db":  "backup db",
+		"MyWorld_backup_auto-20250101120000.fwl": "backup fwl",
+	})
+
+	fileManager := &FileManager{Op: RESTORE, World: "MyWorld", Timestamp: "20250101120000"}
+	result, err := fileManager.Restore()
+	require.NoError(t, err)
+
+	assert.Equal(t, "backup db", readTestWorld(t, "MyWorld.db"))
+	assert.Equal(t, "backup fwl", readTestWorld(t, "MyWorld.fwl"))
+	assert.Equal(t, []string{"MyWorld_backup_auto-20250101120000.db", "MyWorld_backup_auto-20250101120000.fwl"}, result.Backup)
+	assert.Equal(t, int64(len("backup db")+len("backup fwl")), result.Bytes)
+
+	// The backup is left in place and the world it replaced was snapshotted
+	assert.Equal(t, "backup db", readTestWorld(t, "MyWorld_backup_auto-20250101120000.db"))
+	require.Len(t, result.Snapshot, 2)
+	snapshot := ParseWorldBackup(result.Snapshot[0])
+	require.NotNil(t, snapshot)
+	assert.Equal(t, BackupLabelRestore, snapshot.Label)
+	assert.Equal(t, "current db", readTestWorld(t, result.Snapshot[0]))
+	assert.Equal(t, "current fwl", readTestWorld(t, result.Snapshot[1]))
+
+	// The snapshot can itself be restored to undo the restore
+	undo := &FileManager{Op: RESTORE, World: "MyWorld", Timestamp: snapshot.Timestamp}
+	_, err = undo.Restore()
+	require.NoError(t, err)
+	assert.Equal(t, "current db", readTestWorld(t, "MyWorld.db"))
+	assert.Equal(t, "current fwl", readTestWorld(t, "MyWorld.fwl"))
+}
+
+func TestRestore_WorldDoesNotExist(t *testing.T) {
+	makeTestWorlds(t, map[string]string{
+		"MyWorld_backup_auto-20250101120000.db":  "backup db",
+		"MyWorld_backup_auto-20250101120000.fwl": "backup fwl",
+	})
+
+	result, err := (&FileManager{Op: RESTORE, World: "MyWorld", Timestamp: "20250101120000"}).Restore()
+	require.NoError(t, err)
+	assert.Empty(t, result.Snapshot)
+	assert.Equal(t, "backup db", readTestWorld(t, "MyWorld.db"))
+	assert.Equal(t, "backup fwl", readTestWorld(t, "MyWorld.fwl"))
+}
+
+func TestRestore_Errors(t *testing.T) {
+	tests := []struct {
+		name      string
+		files     map[string]string
+		world     string
+		timestamp string
+	}{
+		{
+			name:      "no backup",
+			files:     map[string]string{"MyWorld_backup_auto-20250101120000.db": "db", "MyWorld_backup_auto-20250101120000.fwl": "fwl"},
+			world:     "MyWorld",
+			timestamp: "20250101130000",
+		},
+		{
+			name:      "backup of another world",
+			files:     map[string]string{"Other_backup_auto-20250101120000.db": "db", "Other_backup_auto-20250101120000.fwl": "fwl"},
+			world:     "MyWorld",
+			timestamp: "20250101120000",
+		},
+		{
+			name:      "missing fwl",
+			files:     map[string]string{"MyWorld_backup_auto-20250101120000.db": "db"},
+			world:     "MyWorld",
+			timestamp: "20250101120000",
+		},
+		{
+			name:      "missing db",
+			files:     map[string]string{"MyWorld_backup_auto-20250101120000.fwl": "fwl"},
+			world:     "MyWorld",
+			timestamp: "20250101120000",
+		},
+		{
+			name: "ambiguous",
+			files: map[string]string{
+				"MyWorld_backup_auto-20250101120000.db": "db", "MyWorld_backup_auto-20250101120000.fwl": "fwl",
+				"MyWorld_backup_restore-20250101120000.db": "db", "MyWorld_backup_restore-20250101120000.fwl": "fwl",
+			},
+			world:     "MyWorld",
+			timestamp: "20250101120000",
+		},
+		{
+			name:      "world outside the backups directory",
+			files:     map[string]string{},
+			world:     "../MyWorld",
+			timestamp: "20250101120000",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.files["MyWorld.db"] = "current db"
+			tt.files["MyWorld.fwl"] = "current fwl"
+			makeTestWorlds(t, tt.files)
+
+			_, err := (&FileManager{Op: RESTORE, World: tt.world, Timestamp: tt.timestamp}).Restore()
+			assert.Error(t, err)
+
+			// Nothing was touched
+			assert.Equal(t, "current db", readTestWorld(t, "MyWorld.db"))
+			assert.Equal(t, "current fwl", readTestWorld(t, "MyWorld.fwl"))
+			entries, err := os.ReadDir(BACKUPS_DIR)
+			require.NoError(t, err)
+			assert.Len(t, entries, len(tt.files))
+		})
+	}
+}
+
+func TestRevertWorld(t *testing.T) {
+	makeTestWorlds(t, map[string]string{
+		"MyWorld.db":  "restored db",
+		"MyWorld.fwl": "restored fwl",
+		"MyWorld_backup_restore-20250101120000.db": "current db",
+	})
+
+	snapshot := map[string]string{".db": filepath.Join(BACKUPS_DIR, "MyWorld_backup_restore-20250101120000.db")}
+	require.NoError(t, revertWorld(BACKUPS_DIR, "MyWorld", []string{".db", ".fwl"}, snapshot))
+	assert.Equal(t, "current db", readTestWorld(t, "MyWorld.db"))
+	assert.NoFileExists(t, filepath.Join(BACKUPS_DIR, "MyWorld.fwl"))
+
+	// Files already missing are fine but snapshot files which can't be read aren't
+	require.NoError(t, revertWorld(BACKUPS_DIR, "MyWorld", []string{".fwl"}, snapshot))
+	snapshot[".db"] = filepath.Join(BACKUPS_DIR, "missing.db")
+	assert.Error(t, revertWorld(BACKUPS_DIR, "MyWorld", []string{".db"}, snapshot))
+}
+
+func TestUnusedBackupTimestamp(t *testing.T) {
+	makeTestWorlds(t, map[string]string{
+		"MyWorld_backup_restore-20250101120000.db": "db",
+		"MyWorld_backup_auto-20250101120001.fwl":   "fwl",
+	})
+
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.Local)
+	assert.Equal(t, "20250101120002", unusedBackupTimestamp(BACKUPS_DIR, "MyWorld", now))
+	assert.Equal(t, "20250101120000", unusedBackupTimestamp(BACKUPS_DIR, "Other", now))
+	assert.Equal(t, "20250101120000", unusedBackupTimestamp(filepath.Join(BACKUPS_DIR, "missing"), "MyWorld", now))
+}
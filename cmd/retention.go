@@ -0,0 +1,182 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+)
+
+// Default retention applied to world backups when the BACKUP_KEEP_* environment variables are unset.
+const (
+	defaultKeepLast   = 10
+	defaultKeepDaily  = 7
+	defaultKeepWeekly = 4
+)
+
+// RetentionPolicy Decides which world backups a prune op keeps. A backup is kept when any rule keeps it and a rule set to
+// 0 keeps nothing.
+type RetentionPolicy struct {
+	KeepLast     int  // The number of most recent backups kept
+	KeepDaily    int  // The number of days the newest backup of each day is kept for
+	KeepWeekly   int  // The number of weeks the newest backup of each week is kept for
+	DeleteFromS3 bool // Also delete the copies of pruned backups in S3
+}
+
+// MakeRetentionPolicy Creates the retention policy from the BACKUP_KEEP_LAST, BACKUP_KEEP_DAILY, BACKUP_KEEP_WEEKLY and
+// BACKUP_PRUNE_S3 environment variables.
+func MakeRetentionPolicy() *RetentionPolicy {
+	return &RetentionPolicy{
+		KeepLast:     getEnvInt("BACKUP_KEEP_LAST", defaultKeepLast),
+		KeepDaily:    getEnvInt("BACKUP_KEEP_DAILY", defaultKeepDaily),
+		KeepWeekly:   getEnvInt("BACKUP_KEEP_WEEKLY", defaultKeepWeekly),
+		DeleteFromS3: os.Getenv("BACKUP_PRUNE_S3") == "true",
+	}
+}
+
+// backupSet The .db and .fwl files making up one backup of a world.
+type backupSet struct {
+	time  time.Time
+	files []string
+}
+
+// keep Returns the backups the policy keeps out of a world's backups sorted newest first. Within each day and week the
+// newest backup is the one kept.
+func (p *RetentionPolicy) keep(backups []*backupSet, now time.Time) map[*backupSet]bool {
+	kept := map[*backupSet]bool{}
+	days := map[string]bool{}
+	weeks := map[string]bool{}
+	dailyCutoff := now.AddDate(0, 0, -p.KeepDaily)
+	weeklyCutoff := now.AddDate(0, 0, -7*p.KeepWeekly)
+
+	for i, backup := range backups {
+		if i < p.KeepLast {
+			kept[backup] = true
+		}
+
+		day := backup.time.Format(time.DateOnly)
+		if p.KeepDaily > 0 && backup.time.After(dailyCutoff) && !days[day] {
+			kept[backup] = true
+		}
+		days[day] = true
+
+		year, w := backup.time.ISOWeek()
+		week := fmt.Sprintf("%d-%d", year, w)
+		if p.KeepWeekly > 0 && backup.time.After(weeklyCutoff) && !weeks[week] {
+			kept[backup] = true
+		}
+		weeks[week] = true
+	}
+	return kept
+}
+
+// PruneReport Describes the world backups removed by PruneBackups.
+type PruneReport struct {
+	Kept   []string // The file names of the backups kept
+	Pruned []string // The file names of the backups removed
+	Freed  int64
+}
+
+// PruneBackups Removes the world backups in the backups directory which the retention policy doesn't keep. Each world's
+// backups are rotated separately, as are backups with different labels so Valheim's auto backups never push out the
+// snapshots taken before a restore or vice versa. Only the given world's backups are pruned when the file manager has
+// one. The .db and .fwl files of a backup are always kept or removed together. Files which can't be removed are
+// skipped and reported in the returned error along with the report of what was removed.
+func (f *FileManager) PruneBackups(policy *RetentionPolicy, now time.Time) (*PruneReport, error) {
+	if policy.KeepLast <= 0 && policy.KeepDaily <= 0 && policy.KeepWeekly <= 0 {
+		return nil, errors.New("retention policy keeps no backups, set BACKUP_KEEP_LAST, BACKUP_KEEP_DAILY or BACKUP_KEEP_WEEKLY")
+	}
+
+	files, err := os.ReadDir(BACKUPS_DIR)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backups: %w", err)
+	}
+
+	// Backups are grouped by world and label, then by timestamp to pair up their .db and .fwl files.
+	groups := map[string]map[string]*backupSet{}
+	for _, file := range files {
+		backup := ParseWorldBackup(file.Name())
+		if file.IsDir() || backup == nil || (f.World != "" && backup.World != f.World) {
+			continue
+		}
+
+		group := WorldBackupName(backup.World, backup.Label, "", "")
+		if groups[group] == nil {
+			groups[group] = map[string]*backupSet{}
+		}
+		if groups[group][backup.Timestamp] == nil {
+			groups[group][backup.Timestamp] = &backupSet{time: backup.Time}
+		}
+		groups[group][backup.Timestamp].files = append(groups[group][backup.Timestamp].files, file.Name())
+	}
+
+	report := &PruneReport{}
+	var errs []error
+	for _, group := range groups {
+		var backups []*backupSet
+		for _, backup := range group {
+			backups = append(backups, backup)
+		}
+		sort.Slice(backups, func(i, j int) bool {
+			return backups[i].time.After(backups[j].time)
+		})
+
+		kept := policy.keep(backups, now)
+		for _, backup := range backups {
+			if kept[backup] {
+				report.Kept = append(report.Kept, backup.files...)
+				continue
+			}
+
+			for _, name := range backup.files {
+				path := filepath.Join(BACKUPS_DIR, name)
+				info, err := os.Stat(path)
+				if err == nil {
+					err = os.Remove(path)
+				}
+				if err != nil {
+					errs = append(errs, fmt.Errorf("failed to remove backup %s: %w", name, err))
+					continue
+				}
+
+				report.Pruned = append(report.Pruned, name)
+				report.Freed += info.Size()
+			}
+		}
+	}
+
+	slices.Sort(report.Kept)
+	slices.Sort(report.Pruned)
+	log.Infof("pruned %d backup files freeing %d bytes, kept %d", len(report.Pruned), report.Freed, len(report.Kept))
+	return report, errors.Join(errs...)
+}
+
+// DeleteBackups Deletes the copies of the given world backup files from the user's backups in S3. Deleting a backup
+// which isn't in S3 isn't an error. Every file is attempted and the errors of the ones which failed are returned.
+func (s *S3Client) DeleteBackups(discordId string, fileNames []string) error {
+	ctx := context.Background()
+	var errs []error
+	for _, name := range fileNames {
+		key := BackupKey(discordId, name)
+		err := s.Retry.Do(ctx, fmt.Sprintf("delete s3://%s/%s", s.BucketName, key), func() error {
+			_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+				Bucket: aws.String(s.BucketName),
+				Key:    aws.String(key),
+			})
+			return err
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete object s3://%v/%v err: %w", s.BucketName, key, err))
+			continue
+		}
+		log.Infof("deleted s3://%s/%s", s.BucketName, key)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMakeRetentionPolicy(t *testing.T) {
+	t.Setenv("BACKUP_KEEP_LAST", "")
+	t.Setenv("BACKUP_KEEP_DAILY", "")
+	t.Setenv("BACKUP_KEEP_WEEKLY", "")
+	t.Setenv("BACKUP_PRUNE_S3", "")
+	assert.Equal(t, &RetentionPolicy{KeepLast: defaultKeepLast, KeepDaily: defaultKeepDaily, KeepWeekly: defaultKeepWeekly}, MakeRetentionPolicy())
+
+	t.Setenv("BACKUP_KEEP_LAST", "3")
+	t.Setenv("BACKUP_KEEP_DAILY", "0")
+	t.Setenv("BACKUP_KEEP_WEEKLY", "12")
+	t.Setenv("BACKUP_PRUNE_S3", "true")
+	assert.Equal(t, &RetentionPolicy{KeepLast: 3, KeepDaily: 0, KeepWeekly: 12, DeleteFromS3: true}, MakeRetentionPolicy())
+}
+
+func TestRetentionPolicy_keep(t *testing.T) {
+	// Wednesday
+	now := time.Date(2025, 1, 15, 12, 0, 0, 0, time.Local)
+
+	// Two backups a day, at 10:00 and 02:00, going back 30 days newest first
+	var backups []*backupSet
+	for day := 0; day < 30; day++ {
+		date := now.AddDate(0, 0, -day)
+		for _, hour := range []int{10, 2} {
+			backups = append(backups, &backupSet{time: time.Date(date.Year(), date.Month(), date.Day(), hour, 0, 0, 0, time.Local)})
+		}
+	}
+
+	tests := []struct {
+		name   string
+		policy RetentionPolicy
+		want   []string
+	}{
+		{
+			name:   "keep last",
+			policy: RetentionPolicy{KeepLast: 3},
+			want:   []string{"2025-01-15 10", "2025-01-15 02", "2025-01-14 10"},
+		},
+		{
+			name:   "keep daily",
+			policy: RetentionPolicy{KeepDaily: 3},
+			want:   []string{"2025-01-15 10", "2025-01-14 10", "2025-01-13 10"},
+		},
+		{
+			name:   "keep weekly",
+			policy: RetentionPolicy{KeepWeekly: 3},
+			// The newest backup of each week with a backup in the last 21 days, which reach back into the week starting
+			// Monday 2024-12-23.
+			want: []string{"2025-01-15 10", "2025-01-12 10", "2025-01-05 10", "2024-12-29 10"},
+		},
+		{
+			name:   "combined",
+			policy: RetentionPolicy{KeepLast: 2, KeepDaily: 2, KeepWeekly: 2},
+			want:   []string{"2025-01-15 10", "2025-01-15 02", "2025-01-14 10", "2025-01-12 10", "2025-01-05 10"},
+		},
+		{
+			name:   "nothing",
+			policy: RetentionPolicy{},
+			want:   nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kept := tt.policy.keep(backups, now)
+			var got []string
+			for _, backup := range backups {
+				if kept[backup] {
+					got = append(got, backup.time.Format("2006-01-02 15"))
+				}
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestPruneBackups(t *testing.T) {
+	makeTestWorlds(t, map[string]string{
+		"MyWorld.db":                                "world",
+		"MyWorld.fwl":                               "world",
+		"MyWorld_backup_auto-20250115100000.db":     "newest",
+		"MyWorld_backup_auto-20250115100000.fwl":    "newest",
+		"MyWorld_backup_auto-20250115020000.db":     "second",
+		"MyWorld_backup_auto-20250115020000.fwl":    "second",
+		"MyWorld_backup_auto-20250114100000.db":     "oldest",
+		"MyWorld_backup_auto-20250114100000.fwl":    "oldest",
+		"MyWorld_backup_restore-20250101100000.db":  "restore",
+		"MyWorld_backup_restore-20250101100000.fwl": "restore",
+		"Other_backup_auto-20250101100000.db":       "other",
+		"Other_backup_auto-20250101000000.db":       "other",
+	})
+	now := time.Date(2025, 1, 15, 12, 0, 0, 0, time.Local)
+
+	t.Run("single world", func(t *testing.T) {
+		report, err := (&FileManager{Op: PRUNE, World: "MyWorld"}).PruneBackups(&RetentionPolicy{KeepLast: 1}, now)
+		require.NoError(t, err)
+		assert.Equal(t, []string{
+			"MyWorld_backup_auto-20250114100000.db", "MyWorld_backup_auto-20250114100000.fwl",
+			"MyWorld_backup_auto-20250115020000.db", "MyWorld_backup_auto-20250115020000.fwl",
+		}, report.Pruned)
+		assert.Equal(t, []string{
+			"MyWorld_backup_auto-20250115100000.db", "MyWorld_backup_auto-20250115100000.fwl",
+			"MyWorld_backup_restore-20250101100000.db", "MyWorld_backup_restore-20250101100000.fwl",
+		}, report.Kept)
+		assert.Equal(t, int64(4*len("oldest")), report.Freed)
+
+		for _, name := range report.Pruned {
+			assert.NoFileExists(t, filepath.Join(BACKUPS_DIR, name))
+		}
+		assert.FileExists(t, filepath.Join(BACKUPS_DIR, "MyWorld.db"))
+		assert.FileExists(t, filepath.Join(BACKUPS_DIR, "Other_backup_auto-20250101000000.db"))
+	})
+
+	t.Run("every world", func(t *testing.T) {
+		report, err := (&FileManager{Op: PRUNE}).PruneBackups(&RetentionPolicy{KeepLast: 1}, now)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Other_backup_auto-20250101000000.db"}, report.Pruned)
+		assert.Len(t, report.Kept, 5)
+	})
+
+	t.Run("policy which keeps nothing", func(t *testing.T) {
+		_, err := (&FileManager{Op: PRUNE}).PruneBackups(&RetentionPolicy{}, now)
+		assert.Error(t, err)
+		entries, err := os.ReadDir(BACKUPS_DIR)
+		require.NoError(t, err)
+		assert.Len(t, entries, 7)
+	})
+}
+
+func TestS3Client_DeleteBackups(t *testing.T) {
+	store := newFakeObjectStore()
+	store.put("valheim-backups-auto/123/MyWorld_backup_auto-20250101100000.db", []byte("db"))
+	store.put("valheim-backups-auto/123/MyWorld_backup_auto-20250102100000.db", []byte("db"))
+	s3Client := &S3Client{BucketName: "test-bucket", client: store}
+
+	err := s3Client.DeleteBackups("123", []string{"MyWorld_backup_auto-20250101100000.db", "MyWorld_backup_auto-20250101100000.fwl"})
+	require.NoError(t, err)
+	assert.NotContains(t, store.objects, "valheim-backups-auto/123/MyWorld_backup_auto-20250101100000.db")
+	assert.Contains(t, store.objects, "valheim-backups-auto/123/MyWorld_backup_auto-20250102100000.db")
+}
@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	amqp "github.com/rabbitmq/amqp091-go"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"math/rand/v2"
+	"net"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultMaxAttempts = 5
+	defaultBaseDelayMs = 500
+	defaultMaxDelayMs  = 30000
+)
+
+// RetryPolicy Retries an operation with jittered exponential backoff. It is shared by the S3, HearthHub API and
+// RabbitMQ integrations so a transient 503 or DNS hiccup doesn't fail the whole Job. A nil policy makes a single attempt.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Retryable   func(err error) bool
+	sleep       func(ctx context.Context, d time.Duration) error
+}
+
+// RetryableError Marks an error as transient so the RetryPolicy will retry it regardless of its underlying type.
+type RetryableError struct {
+	Err error
+}
+
+func (e *RetryableError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryableError) Unwrap() error {
+	return e.Err
+}
+
+// MakeRetryPolicy Creates a new retry policy configured from the RETRY_MAX_ATTEMPTS, RETRY_BASE_DELAY_MS and
+// RETRY_MAX_DELAY_MS environment variables.
+func MakeRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts: getEnvInt("RETRY_MAX_ATTEMPTS", defaultMaxAttempts),
+		BaseDelay:   time.Duration(getEnvInt64("RETRY_BASE_DELAY_MS", defaultBaseDelayMs)) * time.Millisecond,
+		MaxDelay:    time.Duration(getEnvInt64("RETRY_MAX_DELAY_MS", defaultMaxDelayMs)) * time.Millisecond,
+		Retryable:   IsRetryable,
+		sleep:       sleepContext,
+	}
+}
+
+// Do Runs fn until it succeeds, returns an error which isn't retryable, or the maximum number of attempts is reached.
+// The error from the last attempt is returned as is.
+func (r *RetryPolicy) Do(ctx context.Context, name string, fn func() error) error {
+	if r == nil {
+		return fn()
+	}
+
+	retryable := r.Retryable
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+
+	sleep := r.sleep
+	if sleep == nil {
+		sleep = sleepContext
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if err == nil || attempt >= r.MaxAttempts || !retryable(err) {
+			return err
+		}
+
+		delay := r.backoff(attempt)
+		log.Warnf("%s failed (attempt %d/%d), retrying in %v: %v", name, attempt, r.MaxAttempts, delay, err)
+		if sleepErr := sleep(ctx, delay); sleepErr != nil {
+			return err
+		}
+	}
+}
+
+// backoff Returns a random delay between zero and BaseDelay * 2^(attempt - 1) capped at MaxDelay ("full jitter") so
+// concurrent Jobs retrying against the same service don't retry in lockstep.
+func (r *RetryPolicy) backoff(attempt int) time.Duration {
+	ceiling := r.MaxDelay
+	if shift := attempt - 1; shift < 32 && r.BaseDelay<<shift > 0 && r.BaseDelay<<shift < ceiling {
+		ceiling = r.BaseDelay << shift
+	}
+
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int64N(int64(ceiling) + 1))
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// IsRetryable Classifies errors which are likely to succeed if the operation is tried again: network and DNS failures,
+// dropped connections, HTTP 408/429/5xx responses, recoverable AMQP errors and corrupt downloads.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var retryableErr *RetryableError
+	if errors.As(err, &retryableErr) {
+		return true
+	}
+
+	var statusErr interface{ HTTPStatusCode() int }
+	if errors.As(err, &statusErr) {
+		return isRetryableStatus(statusErr.HTTPStatusCode())
+	}
+
+	var amqpErr *amqp.Error
+	if errors.As(err, &amqpErr) {
+		return amqpErr.Recover
+	}
+
+	var checksumErr *ChecksumMismatchError
+	if errors.As(err, &checksumErr) {
+		return true
+	}
+
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var opErr *net.OpError
+	if errors.As(err, &dnsErr) || errors.As(err, &opErr) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return true
+	}
+
+	return errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE)
+}
+
+func isRetryableStatus(statusCode int) bool {
+	return statusCode == 408 || statusCode == 429 || statusCode >= 500
+}
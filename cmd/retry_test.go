@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// makeTestRetryPolicy Creates a retry policy which records the delays it would have slept for instead of sleeping.
+func makeTestRetryPolicy(maxAttempts int, delays *[]time.Duration) *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts: maxAttempts,
+		BaseDelay:   100 * time.Millisecond,
+		MaxDelay:    time.Second,
+		Retryable:   IsRetryable,
+		sleep: func(ctx context.Context, d time.Duration) error {
+			if delays != nil {
+				*delays = append(*delays, d)
+			}
+			return nil
+		},
+	}
+}
+
+func TestMakeRetryPolicy(t *testing.T) {
+	policy := MakeRetryPolicy()
+	assert.Equal(t, defaultMaxAttempts, policy.MaxAttempts)
+	assert.Equal(t, defaultBaseDelayMs*time.Millisecond, policy.BaseDelay)
+	assert.Equal(t, defaultMaxDelayMs*time.Millisecond, policy.MaxDelay)
+
+	t.Setenv("RETRY_MAX_ATTEMPTS", "2")
+	t.Setenv("RETRY_BASE_DELAY_MS", "10")
+	t.Setenv("RETRY_MAX_DELAY_MS", "not-a-number")
+	policy = MakeRetryPolicy()
+	assert.Equal(t, 2, policy.MaxAttempts)
+	assert.Equal(t, 10*time.Millisecond, policy.BaseDelay)
+	assert.Equal(t, defaultMaxDelayMs*time.Millisecond, policy.MaxDelay)
+}
+
+func TestRetryPolicy_Do(t *testing.T) {
+	transient := &RetryableError{Err: errors.New("503 service unavailable")}
+	permanent := errors.New("403 forbidden")
+
+	tests := []struct {
+		name         string
+		maxAttempts  int
+		errs         []error
+		wantAttempts int
+		wantErr      error
+	}{
+		{name: "succeeds first time", maxAttempts: 3, errs: []error{nil}, wantAttempts: 1},
+		{name: "succeeds after transient failures", maxAttempts: 3, errs: []error{transient, transient, nil}, wantAttempts: 3},
+		{name: "gives up after max attempts", maxAttempts: 3, errs: []error{transient, transient, transient, nil}, wantAttempts: 3, wantErr: transient},
+		{name: "does not retry permanent errors", maxAttempts: 3, errs: []error{permanent, nil}, wantAttempts: 1, wantErr: permanent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var delays []time.Duration
+			policy := makeTestRetryPolicy(tt.maxAttempts, &delays)
+
+			attempts := 0
+			err := policy.Do(context.Background(), "test", func() error {
+				err := tt.errs[attempts]
+				attempts++
+				return err
+			})
+
+			assert.Equal(t, tt.wantErr, err)
+			assert.Equal(t, tt.wantAttempts, attempts)
+			assert.Len(t, delays, tt.wantAttempts-1)
+		})
+	}
+}
+
+func TestRetryPolicy_DoNilPolicy(t *testing.T) {
+	var policy *RetryPolicy
+	attempts := 0
+	err := policy.Do(context.Background(), "test", func() error {
+		attempts++
+		return &RetryableError{Err: errors.New("boom")}
+	})
+	require.Error(t, err)
+	assert.Equal(t, 1, attempts)
+}
+
+func TestRetryPolicy_DoCancelledContext(t *testing.T) {
+	policy := &RetryPolicy{MaxAttempts: 5, BaseDelay: time.Hour, MaxDelay: time.Hour}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	attempts := 0
+	err := policy.Do(ctx, "test", func() error {
+		attempts++
+		return &RetryableError{Err: errors.New("boom")}
+	})
+	require.Error(t, err)
+	assert.Equal(t, 1, attempts)
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := makeTestRetryPolicy(10, nil)
+	for attempt := 1; attempt <= 10; attempt++ {
+		ceiling := min(policy.BaseDelay<<(attempt-1), policy.MaxDelay)
+		for i := 0; i < 20; i++ {
+			delay := policy.backoff(attempt)
+			assert.GreaterOrEqual(t, delay, time.Duration(0))
+			assert.LessOrEqual(t, delay, ceiling)
+		}
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "plain error", err: errors.New("invalid op"), want: false},
+		{name: "context cancelled", err: context.Canceled, want: false},
+		{name: "marked retryable", err: &RetryableError{Err: errors.New("boom")}, want: true},
+		{name: "dns error", err: &net.DNSError{Err: "no such host", Name: "s3.amazonaws.com"}, want: true},
+		{name: "connection refused", err: fmt.Errorf("dial: %w", syscall.ECONNREFUSED), want: true},
+		{name: "connection reset", err: fmt.Errorf("read: %w", syscall.ECONNRESET), want: true},
+		{name: "unexpected eof", err: fmt.Errorf("body: %w", io.ErrUnexpectedEOF), want: true},
+		{name: "s3 503", err: makeResponseError(503), want: true},
+		{name: "s3 429", err: makeResponseError(429), want: true},
+		{name: "s3 404", err: makeResponseError(404), want: false},
+		{name: "s3 403", err: makeResponseError(403), want: false},
+		{name: "s3 412", err: makeResponseError(412), want: false},
+		{name: "s3 412 from a download", err: retryIfReplaced(makeResponseError(412)), want: true},
+		{name: "recoverable amqp error", err: &amqp.Error{Code: 320, Recover: true}, want: true},
+		{name: "unrecoverable amqp error", err: &amqp.Error{Code: 403, Recover: false}, want: false},
+		{name: "checksum mismatch", err: &ChecksumMismatchError{Key: "foo"}, want: true},
+		{name: "unsafe archive", err: &UnsafePathError{Entry: "../foo"}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, IsRetryable(tt.err))
+		})
+	}
+}
+
+func makeResponseError(statusCode int) error {
+	return fmt.Errorf("operation error S3: GetObject: %w", &smithyhttp.ResponseError{
+		Response: &smithyhttp.Response{Response: &http.Response{StatusCode: statusCode}},
+		Err:      errors.New(http.StatusText(statusCode)),
+	})
+}
@@ -1,105 +1,446 @@
 package cmd
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	log "github.com/sirupsen/logrus"
 	"io"
 	"os"
+	"path"
+	"path/filepath"
 	"strings"
+	"sync"
+)
+
+const (
+	defaultPartSizeMb  = 16
+	defaultConcurrency = 4
 )
 
 type S3Client struct {
-	BucketName string
-	client     ObjectStore
+	BucketName      string
+	PartSize        int64 // Objects larger than this are downloaded as concurrent ranged GETs of this size
+	Concurrency     int   // The number of parts downloaded at once. A value of 1 disables ranged downloads
+	Retry           *RetryPolicy
+	Cache           *DownloadCache // Nil disables the download cache
+	UploadSnapshots bool           // Also upload the snapshot taken of a world before it's overwritten to S3
+	client          ObjectStore
 }
 
 type ObjectStore interface {
 	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error)
+	UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error)
+	CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error)
+	AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error)
+	DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
 }
 
-// MakeS3Client Creates a new S3 Client object.
-func MakeS3Client(cfg aws.Config) *S3Client {
+// MakeS3Client Creates a new S3 Client object. The part size (in MB) and concurrency used for large downloads can be
+// tuned with the S3_PART_SIZE_MB and S3_CONCURRENCY environment variables. Downloads are cached when
+// DOWNLOAD_CACHE_DIR is set and world snapshots are uploaded when WORLD_SNAPSHOT_S3 is true. S3 compatible stores are
+// used instead of AWS with the variables read by MakeS3Endpoint.
+func MakeS3Client(cfg aws.Config, retry *RetryPolicy) *S3Client {
+	endpoint := MakeS3Endpoint()
+	endpoint.log()
+
 	return &S3Client{
-		BucketName: os.Getenv("BUCKET_NAME"),
-		client:     s3.NewFromConfig(cfg),
+		BucketName:      os.Getenv("BUCKET_NAME"),
+		PartSize:        getEnvInt64("S3_PART_SIZE_MB", defaultPartSizeMb) * 1024 * 1024,
+		Concurrency:     getEnvInt("S3_CONCURRENCY", defaultConcurrency),
+		Retry:           retry,
+		Cache:           MakeDownloadCache(),
+		UploadSnapshots: os.Getenv("WORLD_SNAPSHOT_S3") == "true",
+		client:          s3.NewFromConfig(cfg, endpoint.options),
 	}
 }
 
+// DownloadResult Describes a file written to disk by DownloadFile.
+type DownloadResult struct {
+	Bytes    int64
+	ETag     string
+	SHA256   string // Hex encoded SHA-256 of the bytes written to disk
+	Verified string // The checksum algorithm the file was verified against S3 with or empty when S3 had none to offer
+	Cached   bool   // The file was copied from the download cache rather than downloaded
+}
+
 // DownloadFile Downloads a file (zip, config, world save or otherwise) from S3 and writes it to the specified destination on disk.
+// The object body is streamed to a temporary file in the destination directory which is renamed over the destination
+// once the download completes so a failed download never leaves a truncated file behind. Objects larger than the
+// client's part size are fetched as concurrent byte-range GETs and reassembled in place. Before the file is moved into
+// place its contents are checked against the object's S3 checksum (or ETag) and a ChecksumMismatchError is returned
+// if they differ. Failed downloads are retried according to the client's retry policy. When the client has a download
+// cache and it holds a copy of the object with the same ETag the copy is used instead of downloading the object again.
 // This function does not unzip the file.
-func (s *S3Client) DownloadFile(fileManager *FileManager) error {
+func (s *S3Client) DownloadFile(fileManager *FileManager) (*DownloadResult, error) {
 	if fileManager.Op == WRITE || fileManager.Op == COPY {
-		result, err := s.client.GetObject(context.Background(), &s3.GetObjectInput{
-			Bucket: aws.String(s.BucketName),
-			Key:    aws.String(fileManager.Prefix),
+		ctx := context.Background()
+
+		var result *DownloadResult
+		err := s.Retry.Do(ctx, fmt.Sprintf("download s3://%s/%s", s.BucketName, fileManager.Prefix), func() error {
+			var err error
+			result, err = s.downloadFile(ctx, fileManager)
+			return err
 		})
 		if err != nil {
-			return errors.New(fmt.Sprintf("failed to get object s3://%v/%v err: %v", s.BucketName, fileManager.Prefix, err))
+			return nil, err
 		}
 
-		defer result.Body.Close()
+		if result.Verified == "" {
+			log.Warnf("no checksum available to verify s3://%s/%s, sha256: %s", s.BucketName, fileManager.Prefix, result.SHA256)
+		} else {
+			log.Infof("verified s3://%s/%s using %s checksum, sha256: %s", s.BucketName, fileManager.Prefix, result.Verified, result.SHA256)
+		}
+
+		if result.Cached {
+			log.Infof("copied %d bytes of s3://%s/%s from the download cache", result.Bytes, s.BucketName, fileManager.Prefix)
+		} else {
+			log.Infof("downloaded %d bytes from s3://%s/%s", result.Bytes, s.BucketName, fileManager.Prefix)
+		}
+		return result, nil
+	} else {
+		log.Infof("skipping s3 download of file: file op is %s", fileManager.Op)
+		return &DownloadResult{}, nil
+	}
+}
+
+// downloadFile Makes a single attempt at downloading and verifying the file.
+func (s *S3Client) downloadFile(ctx context.Context, fileManager *FileManager) (*DownloadResult, error) {
+	head, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:       aws.String(s.BucketName),
+		Key:          aws.String(fileManager.Prefix),
+		ChecksumMode: types.ChecksumModeEnabled,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to head object s3://%v/%v err: %w", s.BucketName, fileManager.Prefix, err)
+	}
 
-		log.Infof("creating file with name: %s in %s", fileManager.FileName, fileManager.FileDestinationPath)
-		file, err := os.Create(fileManager.FileDestinationPath)
+	if result := s.copyFromCache(fileManager, head); result != nil {
+		return result, nil
+	}
 
+	size := aws.ToInt64(head.ContentLength)
+	log.Infof("creating file with name: %s in %s", fileManager.FileName, fileManager.FileDestinationPath)
+
+	result := &DownloadResult{ETag: aws.ToString(head.ETag)}
+	result.Bytes, err = writeFileAtomic(fileManager.FileDestinationPath, func(file *os.File) (int64, error) {
+		var written int64
+		var err error
+		if s.Concurrency > 1 && s.PartSize > 0 && size > s.PartSize {
+			written, err = s.downloadParts(ctx, fileManager.Prefix, head.ETag, size, file)
+		} else {
+			written, err = s.downloadObject(ctx, fileManager.Prefix, head.ETag, file)
+		}
 		if err != nil {
-			log.Errorf("failed to create file %v err: %v", fileManager.Prefix, err)
-			return err
+			return 0, err
 		}
 
-		defer file.Close()
-		body, err := io.ReadAll(result.Body)
+		result.SHA256, result.Verified, err = verifyChecksum(fileManager.Prefix, io.NewSectionReader(file, 0, written), head)
+		return written, err
+	})
+
+	if err != nil {
+		log.Errorf("failed to write object body from %v to %v err: %v", fileManager.Prefix, fileManager.FileDestinationPath, err)
+		return nil, err
+	}
 
+	if s.Cache != nil && result.ETag != "" {
+		entry := &cacheEntry{Bucket: s.BucketName, Key: fileManager.Prefix, ETag: result.ETag, Size: result.Bytes, SHA256: result.SHA256}
+		if err := s.Cache.put(entry, fileManager.FileDestinationPath); err != nil {
+			log.Warnf("failed to cache s3://%s/%s: %v", s.BucketName, fileManager.Prefix, err)
+		}
+	}
+
+	return result, nil
+}
+
+// copyFromCache Copies the object from the download cache when the cache holds a copy with the same ETag as the object
+// in S3. The copy is verified against the object's checksum the same way a download is. Returns nil when there's no
+// usable copy and the object needs downloading.
+func (s *S3Client) copyFromCache(fileManager *FileManager, head *s3.HeadObjectOutput) *DownloadResult {
+	etag := aws.ToString(head.ETag)
+	if s.Cache == nil || etag == "" {
+		return nil
+	}
+
+	entry, blob := s.Cache.get(s.BucketName, fileManager.Prefix, etag)
+	if entry == nil {
+		return nil
+	}
+
+	result := &DownloadResult{ETag: etag, Cached: true}
+	var err error
+	result.Bytes, err = writeFileAtomic(fileManager.FileDestinationPath, func(file *os.File) (int64, error) {
+		src, err := os.Open(blob)
 		if err != nil {
-			log.Errorf("failed to read object body from %v error: %v", fileManager.Prefix, err)
+			return 0, err
+		}
+		defer src.Close()
+
+		written, err := io.Copy(file, src)
+		if err != nil {
+			return 0, err
+		}
+
+		result.SHA256, result.Verified, err = verifyChecksum(fileManager.Prefix, io.NewSectionReader(file, 0, written), head)
+		return written, err
+	})
+
+	if err != nil {
+		log.Warnf("failed to copy s3://%s/%s from the download cache, downloading it instead: %v", s.BucketName, fileManager.Prefix, err)
+		s.Cache.remove(cacheId(s.BucketName, fileManager.Prefix, etag))
+		return nil
+	}
+	return result
+}
+
+// PruneCache Removes cached copies of objects which have since been replaced or deleted in S3 and then evicts the least
+// recently used entries until the cache is under its maximum size.
+func (s *S3Client) PruneCache() (*CachePruneReport, error) {
+	if s.Cache == nil {
+		return nil, errors.New("the download cache is disabled, set DOWNLOAD_CACHE_DIR to enable it")
+	}
+
+	ctx := context.Background()
+	return s.Cache.prune(func(entry *cacheEntry) (bool, error) {
+		var head *s3.HeadObjectOutput
+		err := s.Retry.Do(ctx, fmt.Sprintf("head s3://%s/%s", entry.Bucket, entry.Key), func() error {
+			var err error
+			head, err = s.client.HeadObject(ctx, &s3.HeadObjectInput{
+				Bucket: aws.String(entry.Bucket),
+				Key:    aws.String(entry.Key),
+			})
 			return err
+		})
+		if isNotFound(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return aws.ToString(head.ETag) == entry.ETag, nil
+	})
+}
+
+// isNotFound Reports whether err is S3 saying an object doesn't exist.
+func isNotFound(err error) bool {
+	var notFound *types.NotFound
+	var noSuchKey *types.NoSuchKey
+	var statusErr interface{ HTTPStatusCode() int }
+	return errors.As(err, &notFound) || errors.As(err, &noSuchKey) || (errors.As(err, &statusErr) && statusErr.HTTPStatusCode() == 404)
+}
+
+// downloadObject Streams the whole object into the given file with a single GET.
+func (s *S3Client) downloadObject(ctx context.Context, key string, etag *string, file *os.File) (int64, error) {
+	result, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket:  aws.String(s.BucketName),
+		Key:     aws.String(key),
+		IfMatch: etag,
+	})
+	if err != nil {
+		return 0, retryIfReplaced(fmt.Errorf("failed to get object s3://%v/%v err: %w", s.BucketName, key, err))
+	}
+	defer result.Body.Close()
+
+	return io.Copy(file, result.Body)
+}
+
+// downloadParts Splits the object into PartSize byte ranges and downloads them concurrently, writing each part at its
+// offset in the given file. Every part GET is conditional on the ETag returned by HEAD so an object which is replaced
+// mid-download fails rather than producing a file stitched together from two versions.
+func (s *S3Client) downloadParts(ctx context.Context, key string, etag *string, size int64, file *os.File) (int64, error) {
+	if err := file.Truncate(size); err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	parts := make(chan int64)
+	errs := make(chan error, s.Concurrency)
+	var wg sync.WaitGroup
+
+	log.Infof("downloading s3://%s/%s in %d byte parts with concurrency: %d", s.BucketName, key, s.PartSize, s.Concurrency)
+	for i := 0; i < s.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for start := range parts {
+				end := min(start+s.PartSize, size) - 1
+				if err := s.downloadPart(ctx, key, etag, start, end, file); err != nil {
+					errs <- err
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(parts)
+		for start := int64(0); start < size; start += s.PartSize {
+			select {
+			case parts <- start:
+			case <-ctx.Done():
+				return
+			}
 		}
-		_, err = file.Write(body)
+	}()
+
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		return 0, err
+	}
 
+	return size, nil
+}
+
+// downloadPart Downloads the inclusive byte range [start, end] of the object and writes it at the same offset in file.
+func (s *S3Client) downloadPart(ctx context.Context, key string, etag *string, start, end int64, file *os.File) error {
+	result, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket:  aws.String(s.BucketName),
+		Key:     aws.String(key),
+		IfMatch: etag,
+		Range:   aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+	})
+	if err != nil {
+		return retryIfReplaced(fmt.Errorf("failed to get range %d-%d of object s3://%v/%v err: %w", start, end, s.BucketName, key, err))
+	}
+	defer result.Body.Close()
+
+	written, err := io.Copy(io.NewOffsetWriter(file, start), result.Body)
+	if err != nil {
 		return err
-	} else {
-		log.Infof("skipping s3 download of file: file op is delete")
+	}
+
+	if written != end-start+1 {
+		return fmt.Errorf("short read for range %d-%d of object s3://%v/%v: got %d bytes: %w", start, end, s.BucketName, key, written, io.ErrUnexpectedEOF)
+	}
+	return nil
+}
+
+// retryIfReplaced Marks the error of a GET conditional on the ETag returned by HEAD as retryable when S3 answers 412
+// Precondition Failed. That means the object was replaced mid-download so the next attempt HEADs it again and downloads
+// the new version rather than failing the Job.
+func retryIfReplaced(err error) error {
+	var statusErr interface{ HTTPStatusCode() int }
+	if errors.As(err, &statusErr) && statusErr.HTTPStatusCode() == 412 {
+		return &RetryableError{Err: err}
+	}
+	return err
+}
+
+// writeFileAtomic Creates a temporary file in the same directory as path, hands it to write and renames it over path
+// once everything has been written and synced. The temporary file is removed on any error leaving path untouched.
+func writeFileAtomic(path string, write func(file *os.File) (int64, error)) (int64, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), fmt.Sprintf(".%s.*.tmp", filepath.Base(path)))
+	if err != nil {
+		return 0, err
+	}
+
+	written, err := write(tmp)
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+		return 0, err
+	}
+
+	return written, nil
+}
+
+// IconKey Returns the key a mod's icon is stored under which sits alongside the mod i.e. mods/123/ValheimPlus.zip has
+// its icon stored at mods/123/ValheimPlus.icon.png.
+func IconKey(prefix string) string {
+	return path.Join(path.Dir(prefix), packageName(prefix)+".icon.png")
+}
+
+// UploadIcon Uploads the icon bundled with the mod at prefix next to the mod in S3 so the frontend can display it. Returns the key
+// the icon was stored under.
+func (s *S3Client) UploadIcon(prefix string, icon []byte) (string, error) {
+	ctx := context.Background()
+	key := IconKey(prefix)
+	err := s.Retry.Do(ctx, fmt.Sprintf("upload s3://%s/%s", s.BucketName, key), func() error {
+		_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:            aws.String(s.BucketName),
+			Key:               aws.String(key),
+			Body:              bytes.NewReader(icon),
+			ContentType:       aws.String("image/png"),
+			ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to put object s3://%v/%v err: %w", s.BucketName, key, err)
+		}
 		return nil
+	})
+	if err != nil {
+		return "", err
 	}
+
+	log.Infof("uploaded %d byte icon to s3://%s/%s", len(icon), s.BucketName, key)
+	return key, nil
 }
 
 // SyncWorldFiles Synchronizes a .db or .fwl file along with its pair to disk. I.e. if the prefix for the file
 // in s3 ends with .db this will also download the corresponding .fwl file and vice versa. This ensures that world
 // file stay synchronized between S3 and the pvc.
 func SyncWorldFiles(s3Client *S3Client, fileManager *FileManager) error {
-	var tmpManager FileManager
 	if fileManager.Op == WRITE || fileManager.Op == COPY {
-		if strings.HasSuffix(fileManager.Prefix, ".db") {
-			log.Infof("file is a *.db, syncing paired *.fwl")
-			tmpManager = FileManager{
-				Prefix:              fmt.Sprintf("%s%s", strings.TrimSuffix(fileManager.Prefix, ".db"), ".fwl"),
-				FileName:            fmt.Sprintf("%s%s", strings.TrimSuffix(fileManager.FileName, ".db"), ".fwl"),
-				FileDestinationPath: fmt.Sprintf("%s%s", strings.TrimSuffix(fileManager.FileDestinationPath, ".db"), ".fwl"),
-			}
-		} else if strings.HasSuffix(fileManager.Prefix, ".fwl") {
-			log.Infof("file is a *.fwl, syncing paired *.db")
-			tmpManager = FileManager{
-				Prefix:              fmt.Sprintf("%s%s", strings.TrimSuffix(fileManager.Prefix, ".fwl"), ".db"),
-				FileName:            fmt.Sprintf("%s%s", strings.TrimSuffix(fileManager.FileName, ".fwl"), ".db"),
-				FileDestinationPath: fmt.Sprintf("%s%s", strings.TrimSuffix(fileManager.FileDestinationPath, ".fwl"), ".db"),
-			}
-		} else {
+		tmpManager := worldPair(fileManager)
+		if tmpManager == nil {
 			log.Infof("file: %s is not a world file. skipping sync", fileManager.Prefix)
 			return nil
 		}
 
-		err := s3Client.DownloadFile(&tmpManager)
+		_, err := s3Client.DownloadFile(tmpManager)
 		if err != nil {
 			return err
 		}
 
 		log.Infof("synced world file: %s to: %s", tmpManager.Prefix, tmpManager.FileDestinationPath)
 	} else {
-		log.Infof("skipping world sync: op is delete")
+		log.Infof("skipping world sync: op is %s", fileManager.Op)
 	}
 	return nil
 }
+
+// worldPair Returns a file manager for the other file of the world a .db or .fwl file belongs to i.e. the .fwl for a
+// .db. Returns nil when the file isn't a world file.
+func worldPair(fileManager *FileManager) *FileManager {
+	var ext, pairExt string
+	if strings.HasSuffix(fileManager.Prefix, ".db") {
+		log.Infof("file is a *.db, syncing paired *.fwl")
+		ext, pairExt = ".db", ".fwl"
+	} else if strings.HasSuffix(fileManager.Prefix, ".fwl") {
+		log.Infof("file is a *.fwl, syncing paired *.db")
+		ext, pairExt = ".fwl", ".db"
+	} else {
+		return nil
+	}
+
+	return &FileManager{
+		Op:                  fileManager.Op,
+		Prefix:              fmt.Sprintf("%s%s", strings.TrimSuffix(fileManager.Prefix, ext), pairExt),
+		FileName:            fmt.Sprintf("%s%s", strings.TrimSuffix(fileManager.FileName, ext), pairExt),
+		FileDestinationPath: fmt.Sprintf("%s%s", strings.TrimSuffix(fileManager.FileDestinationPath, ext), pairExt),
+	}
+}
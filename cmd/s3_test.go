@@ -3,15 +3,24 @@ package cmd
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -24,13 +33,56 @@ func (m *MockS3Client) GetObject(ctx context.Context, params *s3.GetObjectInput,
 	return args.Get(0).(*s3.GetObjectOutput), args.Error(1)
 }
 
+func (m *MockS3Client) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	args := m.Called(ctx, params, optFns)
+	return args.Get(0).(*s3.HeadObjectOutput), args.Error(1)
+}
+
+func (m *MockS3Client) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	args := m.Called(ctx, params, optFns)
+	return args.Get(0).(*s3.PutObjectOutput), args.Error(1)
+}
+
+func (m *MockS3Client) CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	args := m.Called(ctx, params, optFns)
+	return args.Get(0).(*s3.CreateMultipartUploadOutput), args.Error(1)
+}
+
+func (m *MockS3Client) UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	args := m.Called(ctx, params, optFns)
+	return args.Get(0).(*s3.UploadPartOutput), args.Error(1)
+}
+
+func (m *MockS3Client) CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	args := m.Called(ctx, params, optFns)
+	return args.Get(0).(*s3.CompleteMultipartUploadOutput), args.Error(1)
+}
+
+func (m *MockS3Client) AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	args := m.Called(ctx, params, optFns)
+	return args.Get(0).(*s3.AbortMultipartUploadOutput), args.Error(1)
+}
+
+func (m *MockS3Client) DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	args := m.Called(ctx, params, optFns)
+	return args.Get(0).(*s3.DeleteObjectOutput), args.Error(1)
+}
+
 func TestMakeS3Client(t *testing.T) {
 	cfg := aws.Config{}
 	os.Setenv("BUCKET_NAME", "FOO")
 
-	client := MakeS3Client(cfg)
+	client := MakeS3Client(cfg, nil)
 	assert.NotNil(t, client)
 	assert.Equal(t, client.BucketName, "FOO")
+	assert.Equal(t, int64(defaultPartSizeMb*1024*1024), client.PartSize)
+	assert.Equal(t, defaultConcurrency, client.Concurrency)
+
+	t.Setenv("S3_PART_SIZE_MB", "8")
+	t.Setenv("S3_CONCURRENCY", "2")
+	client = MakeS3Client(cfg, nil)
+	assert.Equal(t, int64(8*1024*1024), client.PartSize)
+	assert.Equal(t, 2, client.Concurrency)
 }
 
 func TestDownloadFile_Success(t *testing.T) {
@@ -44,6 +96,7 @@ func TestDownloadFile_Success(t *testing.T) {
 	defer os.RemoveAll(tmp.Name())
 
 	fileManager := &FileManager{
+		Op:                  WRITE,
 		Prefix:              "test-key",
 		FileName:            tmp.Name(),
 		FileDestinationPath: tmp.Name(),
@@ -53,6 +106,9 @@ func TestDownloadFile_Success(t *testing.T) {
 		client:     mockS3,
 	}
 
+	mockS3.On("HeadObject", mock.Anything, mock.Anything, mock.Anything).Return(&s3.HeadObjectOutput{
+		ContentLength: aws.Int64(int64(len("test content"))),
+	}, nil)
 	mockS3.On("GetObject", mock.Anything, &s3.GetObjectInput{
 		Bucket: aws.String("test-bucket"),
 		Key:    aws.String("test-key"),
@@ -60,9 +116,10 @@ func TestDownloadFile_Success(t *testing.T) {
 		Body: io.NopCloser(bytes.NewReader([]byte("test content"))),
 	}, nil)
 
-	err = s3Client.DownloadFile(fileManager)
+	result, err := s3Client.DownloadFile(fileManager)
 
 	require.NoError(t, err)
+	require.Equal(t, int64(len("test content")), result.Bytes)
 	mockS3.AssertExpectations(t)
 
 	content, err := os.ReadFile(tmp.Name())
@@ -75,6 +132,7 @@ func TestDownloadFile_CreateFileError(t *testing.T) {
 	mockS3 := new(MockS3Client)
 	fs := afero.NewMemMapFs()
 	fileManager := &FileManager{
+		Op:                  WRITE,
 		Prefix:              "test-key",
 		FileName:            "test-file.txt",
 		FileDestinationPath: "/path/to/destination/test-file.txt",
@@ -84,12 +142,9 @@ func TestDownloadFile_CreateFileError(t *testing.T) {
 		client:     mockS3,
 	}
 
-	// Mock the GetObject call
-	mockS3.On("GetObject", mock.Anything, &s3.GetObjectInput{
-		Bucket: aws.String("test-bucket"),
-		Key:    aws.String("test-key"),
-	}, mock.Anything).Return(&s3.GetObjectOutput{
-		Body: io.NopCloser(bytes.NewReader([]byte("test content"))),
+	// Mock the HeadObject call. GetObject is never reached since the destination file can't be created.
+	mockS3.On("HeadObject", mock.Anything, mock.Anything, mock.Anything).Return(&s3.HeadObjectOutput{
+		ContentLength: aws.Int64(int64(len("test content"))),
 	}, nil)
 
 	// Mock the file creation to return an error
@@ -97,11 +152,12 @@ func TestDownloadFile_CreateFileError(t *testing.T) {
 	fs.Create("/path/to/destination/test-file.txt") // Create the file to force an error
 
 	// Execute
-	err := s3Client.DownloadFile(fileManager)
+	_, err := s3Client.DownloadFile(fileManager)
 
 	// Assert
 	require.Error(t, err)
 	mockS3.AssertExpectations(t)
+	mockS3.AssertNotCalled(t, "GetObject", mock.Anything, mock.Anything, mock.Anything)
 }
 
 func TestSyncWorldFiles(t *testing.T) {
@@ -114,6 +170,7 @@ func TestSyncWorldFiles(t *testing.T) {
 
 	mockS3 := new(MockS3Client)
 	fileManager := &FileManager{
+		Op:                  WRITE,
 		Prefix:              "foo/bar/" + tmp.Name(),
 		FileName:            tmp.Name(),
 		FileDestinationPath: tmp.Name(),
@@ -123,6 +180,9 @@ func TestSyncWorldFiles(t *testing.T) {
 		client:     mockS3,
 	}
 
+	mockS3.On("HeadObject", mock.Anything, mock.Anything, mock.Anything).Return(&s3.HeadObjectOutput{
+		ContentLength: aws.Int64(int64(len("test content"))),
+	}, nil)
 	mockS3.On("GetObject", mock.Anything, mock.Anything, mock.Anything).Return(&s3.GetObjectOutput{
 		Body: io.NopCloser(bytes.NewReader([]byte("test content"))),
 	}, nil)
@@ -144,6 +204,7 @@ func TestSyncWorldFilesDB(t *testing.T) {
 
 	mockS3 := new(MockS3Client)
 	fileManager := &FileManager{
+		Op:                  WRITE,
 		Prefix:              "foo/bar" + tmp.Name(),
 		FileName:            tmp.Name(),
 		FileDestinationPath: tmp.Name(),
@@ -153,6 +214,9 @@ func TestSyncWorldFilesDB(t *testing.T) {
 		client:     mockS3,
 	}
 
+	mockS3.On("HeadObject", mock.Anything, mock.Anything, mock.Anything).Return(&s3.HeadObjectOutput{
+		ContentLength: aws.Int64(int64(len("test content"))),
+	}, nil)
 	mockS3.On("GetObject", mock.Anything, mock.Anything, mock.Anything).Return(&s3.GetObjectOutput{
 		Body: io.NopCloser(bytes.NewReader([]byte("test content"))),
 	}, nil)
@@ -176,6 +240,7 @@ func TestSyncWorldFilesNoFiles(t *testing.T) {
 
 	mockS3 := new(MockS3Client)
 	fileManager := &FileManager{
+		Op:                  WRITE,
 		Prefix:              "foo/bar" + tmp.Name(),
 		FileName:            tmp.Name(),
 		FileDestinationPath: tmp.Name(),
@@ -185,6 +250,9 @@ func TestSyncWorldFilesNoFiles(t *testing.T) {
 		client:     mockS3,
 	}
 
+	mockS3.On("HeadObject", mock.Anything, mock.Anything, mock.Anything).Return(&s3.HeadObjectOutput{
+		ContentLength: aws.Int64(int64(len("test content"))),
+	}, nil)
 	mockS3.On("GetObject", mock.Anything, mock.Anything, mock.Anything).Return(&s3.GetObjectOutput{
 		Body: io.NopCloser(bytes.NewReader([]byte("test content"))),
 	}, nil)
@@ -192,3 +260,470 @@ func TestSyncWorldFilesNoFiles(t *testing.T) {
 	err = SyncWorldFiles(s3Client, fileManager)
 	assert.Nil(t, err)
 }
+
+type failingReader struct {
+	data []byte
+	read bool
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	if f.read {
+		return 0, errors.New("connection reset by peer")
+	}
+	f.read = true
+	return copy(p, f.data), nil
+}
+
+func TestDownloadFile_FailedStreamLeavesExistingFile(t *testing.T) {
+	dir := t.TempDir()
+	destination := filepath.Join(dir, "world.db")
+	require.NoError(t, os.WriteFile(destination, []byte("original world"), 0644))
+
+	mockS3 := new(MockS3Client)
+	fileManager := &FileManager{
+		Op:                  WRITE,
+		Prefix:              "test-key",
+		FileName:            "world.db",
+		FileDestinationPath: destination,
+	}
+	s3Client := &S3Client{
+		BucketName: "test-bucket",
+		client:     mockS3,
+	}
+
+	mockS3.On("HeadObject", mock.Anything, mock.Anything, mock.Anything).Return(&s3.HeadObjectOutput{
+		ContentLength: aws.Int64(int64(len("partial"))),
+	}, nil)
+	mockS3.On("GetObject", mock.Anything, mock.Anything, mock.Anything).Return(&s3.GetObjectOutput{
+		Body: io.NopCloser(&failingReader{data: []byte("partial")}),
+	}, nil)
+
+	result, err := s3Client.DownloadFile(fileManager)
+	require.Error(t, err)
+	assert.Nil(t, result)
+
+	content, err := os.ReadFile(destination)
+	require.NoError(t, err)
+	assert.Equal(t, "original world", string(content))
+
+	// The temporary file used while streaming should have been cleaned up
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+// fakeObjectStore An in memory ObjectStore which serves byte ranges and records the ranges requested so that ranged
+// downloads can be tested without AWS. Uploads are checked against their SHA-256 checksums like S3 does.
+type fakeObjectStore struct {
+	mu       sync.Mutex
+	objects  map[string][]byte
+	etags    map[string]string
+	heads    map[string]*s3.HeadObjectOutput // Overrides the generated HeadObject response for a key
+	ranges   []string
+	gets     int               // The number of GetObject calls made
+	failOn   string            // A range which fails when requested
+	getErrs  []error           // Errors returned by successive GetObject calls before they start succeeding
+	replaced map[string][]byte // Data which replaces an object right after it's next HEADed as if uploaded mid-download
+	uploads  map[string]*fakeUpload
+	metadata map[string]map[string]string // The user metadata of uploaded objects
+	failPart int32                        // A part number which fails when uploaded
+}
+
+// fakeUpload A multipart upload made to the fakeObjectStore.
+type fakeUpload struct {
+	key       string
+	metadata  map[string]string
+	parts     map[int32][]byte
+	completed bool
+	aborted   bool
+}
+
+func newFakeObjectStore() *fakeObjectStore {
+	return &fakeObjectStore{
+		objects:  map[string][]byte{},
+		etags:    map[string]string{},
+		heads:    map[string]*s3.HeadObjectOutput{},
+		replaced: map[string][]byte{},
+		uploads:  map[string]*fakeUpload{},
+		metadata: map[string]map[string]string{},
+	}
+}
+
+// put Stores an object with the ETag S3 would generate for a single part upload i.e. the quoted MD5 of the data.
+func (f *fakeObjectStore) put(key string, data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sum := md5.Sum(data)
+	f.objects[key] = data
+	f.etags[key] = fmt.Sprintf(`"%s"`, hex.EncodeToString(sum[:]))
+}
+
+func (f *fakeObjectStore) CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id := fmt.Sprintf("upload-%d", len(f.uploads)+1)
+	f.uploads[id] = &fakeUpload{key: aws.ToString(params.Key), metadata: params.Metadata, parts: map[int32][]byte{}}
+	return &s3.CreateMultipartUploadOutput{UploadId: aws.String(id)}, nil
+}
+
+func (f *fakeObjectStore) UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	data, err := io.ReadAll(params.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	number := aws.ToInt32(params.PartNumber)
+	if number == f.failPart {
+		return nil, errors.New("InternalError")
+	}
+
+	sum := sha256.Sum256(data)
+	if checksum := base64.StdEncoding.EncodeToString(sum[:]); checksum != aws.ToString(params.ChecksumSHA256) {
+		return nil, fmt.Errorf("BadDigest: part %d", number)
+	}
+
+	f.uploads[aws.ToString(params.UploadId)].parts[number] = data
+	return &s3.UploadPartOutput{ETag: aws.String(fmt.Sprintf(`"part-%d"`, number))}, nil
+}
+
+func (f *fakeObjectStore) CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	f.mu.Lock()
+	upload := f.uploads[aws.ToString(params.UploadId)]
+	var data []byte
+	for i, part := range params.MultipartUpload.Parts {
+		if aws.ToInt32(part.PartNumber) != int32(i+1) {
+			f.mu.Unlock()
+			return nil, errors.New("InvalidPartOrder")
+		}
+		data = append(data, upload.parts[aws.ToInt32(part.PartNumber)]...)
+	}
+	upload.completed = true
+	f.mu.Unlock()
+
+	f.put(upload.key, data)
+	f.mu.Lock()
+	f.metadata[upload.key] = upload.metadata
+	f.mu.Unlock()
+	return &s3.CompleteMultipartUploadOutput{}, nil
+}
+
+func (f *fakeObjectStore) AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.uploads[aws.ToString(params.UploadId)].aborted = true
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+func (f *fakeObjectStore) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	data, err := io.ReadAll(params.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(data)
+	if params.ChecksumSHA256 != nil && base64.StdEncoding.EncodeToString(sum[:]) != aws.ToString(params.ChecksumSHA256) {
+		return nil, errors.New("BadDigest")
+	}
+
+	f.put(aws.ToString(params.Key), data)
+	f.mu.Lock()
+	f.metadata[aws.ToString(params.Key)] = params.Metadata
+	f.mu.Unlock()
+	return &s3.PutObjectOutput{ETag: aws.String(f.etags[aws.ToString(params.Key)])}, nil
+}
+
+func (f *fakeObjectStore) DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.objects, aws.ToString(params.Key))
+	delete(f.etags, aws.ToString(params.Key))
+	delete(f.metadata, aws.ToString(params.Key))
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (f *fakeObjectStore) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.objects[aws.ToString(params.Key)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", &types.NotFound{}, aws.ToString(params.Key))
+	}
+	head := &s3.HeadObjectOutput{}
+	if override, ok := f.heads[aws.ToString(params.Key)]; ok {
+		head = override
+	}
+	head.ContentLength = aws.Int64(int64(len(data)))
+	head.ETag = aws.String(f.etags[aws.ToString(params.Key)])
+
+	if newer, ok := f.replaced[aws.ToString(params.Key)]; ok {
+		sum := md5.Sum(newer)
+		f.objects[aws.ToString(params.Key)] = newer
+		f.etags[aws.ToString(params.Key)] = fmt.Sprintf(`"%s"`, hex.EncodeToString(sum[:]))
+		delete(f.replaced, aws.ToString(params.Key))
+	}
+	return head, nil
+}
+
+func (f *fakeObjectStore) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := aws.ToString(params.Key)
+	f.gets++
+	data, ok := f.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("NoSuchKey: %s", key)
+	}
+
+	if len(f.getErrs) > 0 {
+		err := f.getErrs[0]
+		f.getErrs = f.getErrs[1:]
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if params.IfMatch != nil && aws.ToString(params.IfMatch) != f.etags[key] {
+		return nil, makeResponseError(412)
+	}
+
+	if params.Range == nil {
+		return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(data))}, nil
+	}
+
+	rng := aws.ToString(params.Range)
+	f.ranges = append(f.ranges, rng)
+	if rng == f.failOn {
+		return nil, errors.New("InternalError")
+	}
+
+	var start, end int64
+	if _, err := fmt.Sscanf(rng, "bytes=%d-%d", &start, &end); err != nil {
+		return nil, err
+	}
+	end = min(end, int64(len(data))-1)
+	return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(data[start : end+1]))}, nil
+}
+
+func TestDownloadFile_RangedParts(t *testing.T) {
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+
+	tests := []struct {
+		name        string
+		partSize    int64
+		concurrency int
+		wantRanges  int
+	}{
+		{name: "evenly divisible parts", partSize: 100, concurrency: 4, wantRanges: 10},
+		{name: "trailing short part", partSize: 300, concurrency: 3, wantRanges: 4},
+		{name: "object smaller than part size", partSize: 2000, concurrency: 4, wantRanges: 0},
+		{name: "concurrency of one disables ranges", partSize: 100, concurrency: 1, wantRanges: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeObjectStore()
+			store.put("mods/big.zip", data)
+			destination := filepath.Join(t.TempDir(), "big.zip")
+
+			s3Client := &S3Client{
+				BucketName:  "test-bucket",
+				PartSize:    tt.partSize,
+				Concurrency: tt.concurrency,
+				client:      store,
+			}
+
+			result, err := s3Client.DownloadFile(&FileManager{
+				Op:                  WRITE,
+				Prefix:              "mods/big.zip",
+				FileName:            "big.zip",
+				FileDestinationPath: destination,
+			})
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(data)), result.Bytes)
+			assert.Equal(t, ChecksumETag, result.Verified)
+			assert.Len(t, store.ranges, tt.wantRanges)
+
+			content, err := os.ReadFile(destination)
+			require.NoError(t, err)
+			assert.Equal(t, data, content)
+		})
+	}
+}
+
+func TestDownloadFile_RangedPartFailure(t *testing.T) {
+	store := newFakeObjectStore()
+	store.put("mods/big.zip", make([]byte, 1000))
+	store.failOn = "bytes=500-599"
+	dir := t.TempDir()
+
+	s3Client := &S3Client{
+		BucketName:  "test-bucket",
+		PartSize:    100,
+		Concurrency: 4,
+		client:      store,
+	}
+
+	_, err := s3Client.DownloadFile(&FileManager{
+		Op:                  WRITE,
+		Prefix:              "mods/big.zip",
+		FileName:            "big.zip",
+		FileDestinationPath: filepath.Join(dir, "big.zip"),
+	})
+	require.Error(t, err)
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestDownloadFile_ChecksumMismatch(t *testing.T) {
+	tests := []struct {
+		name          string
+		head          *s3.HeadObjectOutput
+		wantAlgorithm string
+	}{
+		{
+			name:          "sha256 mismatch",
+			head:          &s3.HeadObjectOutput{ChecksumSHA256: aws.String("n4bQgYhMfWWaL+qgxVrQFaO/TxsrC4Is0V1sFbDwCgg=")},
+			wantAlgorithm: ChecksumSHA256,
+		},
+		{
+			name:          "crc32c mismatch",
+			head:          &s3.HeadObjectOutput{ChecksumCRC32C: aws.String("AAAAAA==")},
+			wantAlgorithm: ChecksumCRC32C,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeObjectStore()
+			store.put("worlds/world.db", []byte("corrupted world"))
+			store.heads["worlds/world.db"] = tt.head
+
+			dir := t.TempDir()
+			destination := filepath.Join(dir, "world.db")
+			require.NoError(t, os.WriteFile(destination, []byte("original world"), 0644))
+
+			s3Client := &S3Client{
+				BucketName: "test-bucket",
+				client:     store,
+			}
+
+			_, err := s3Client.DownloadFile(&FileManager{
+				Op:                  WRITE,
+				Prefix:              "worlds/world.db",
+				FileName:            "world.db",
+				FileDestinationPath: destination,
+			})
+
+			var mismatch *ChecksumMismatchError
+			require.ErrorAs(t, err, &mismatch)
+			assert.Equal(t, tt.wantAlgorithm, mismatch.Algorithm)
+
+			content, err := os.ReadFile(destination)
+			require.NoError(t, err)
+			assert.Equal(t, "original world", string(content))
+		})
+	}
+}
+
+func TestDownloadFile_Retry(t *testing.T) {
+	store := newFakeObjectStore()
+	store.put("mods/mod.zip", []byte("mod content"))
+	store.getErrs = []error{makeResponseError(503), fmt.Errorf("read: %w", io.ErrUnexpectedEOF)}
+	destination := filepath.Join(t.TempDir(), "mod.zip")
+
+	s3Client := &S3Client{
+		BucketName: "test-bucket",
+		Retry:      makeTestRetryPolicy(3, nil),
+		client:     store,
+	}
+
+	result, err := s3Client.DownloadFile(&FileManager{
+		Op:                  WRITE,
+		Prefix:              "mods/mod.zip",
+		FileName:            "mod.zip",
+		FileDestinationPath: destination,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(len("mod content")), result.Bytes)
+	assert.Empty(t, store.getErrs)
+
+	// A permanent error is returned without retrying
+	store.getErrs = []error{makeResponseError(403), nil}
+	_, err = s3Client.DownloadFile(&FileManager{
+		Op:                  WRITE,
+		Prefix:              "mods/mod.zip",
+		FileName:            "mod.zip",
+		FileDestinationPath: destination,
+	})
+	require.Error(t, err)
+	assert.Len(t, store.getErrs, 1)
+}
+
+func TestDownloadFile_ObjectReplacedMidDownload(t *testing.T) {
+	tests := []struct {
+		name        string
+		concurrency int
+	}{
+		{name: "single get", concurrency: 1},
+		{name: "ranged parts", concurrency: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeObjectStore()
+			store.put("mods/mod.zip", bytes.Repeat([]byte("v1"), 500))
+			store.replaced["mods/mod.zip"] = bytes.Repeat([]byte("v2"), 500)
+			destination := filepath.Join(t.TempDir(), "mod.zip")
+
+			s3Client := &S3Client{
+				BucketName:  "test-bucket",
+				PartSize:    100,
+				Concurrency: tt.concurrency,
+				Retry:       makeTestRetryPolicy(2, nil),
+				client:      store,
+			}
+
+			// The GETs of the first attempt are conditional on the ETag of the replaced object so they fail with a 412
+			// and the second attempt downloads the new object
+			result, err := s3Client.DownloadFile(&FileManager{
+				Op:                  WRITE,
+				Prefix:              "mods/mod.zip",
+				FileName:            "mod.zip",
+				FileDestinationPath: destination,
+			})
+			require.NoError(t, err)
+			assert.Equal(t, store.etags["mods/mod.zip"], result.ETag)
+
+			content, err := os.ReadFile(destination)
+			require.NoError(t, err)
+			assert.Equal(t, bytes.Repeat([]byte("v2"), 500), content)
+		})
+	}
+}
+
+func TestIconKey(t *testing.T) {
+	assert.Equal(t, "mods/123/ValheimPlus.icon.png", IconKey("mods/123/ValheimPlus.zip"))
+	assert.Equal(t, "/mods/general/Mod.icon.png", IconKey("/mods/general/Mod.tar.gz"))
+	assert.Equal(t, "Mod.icon.png", IconKey("Mod.7z"))
+}
+
+func TestUploadIcon(t *testing.T) {
+	store := newFakeObjectStore()
+	s3Client := &S3Client{
+		BucketName: "test-bucket",
+		Retry:      makeTestRetryPolicy(3, nil),
+		client:     store,
+	}
+
+	key, err := s3Client.UploadIcon("mods/123/Mod.zip", []byte("png"))
+	require.NoError(t, err)
+	assert.Equal(t, "mods/123/Mod.icon.png", key)
+	assert.Equal(t, []byte("png"), store.objects[key])
+}
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// BackupLabelPreChange Labels the snapshot of a world taken before a write or copy op overwrites it.
+const BackupLabelPreChange = "prechange"
+
+// WorldSnapshot Describes the snapshot of a world taken before it was overwritten.
+type WorldSnapshot struct {
+	World string
+	Files []string // The paths of the snapshot's .db and .fwl files, empty when the world didn't exist yet
+	Keys  []string // The keys the snapshot was uploaded to, empty unless snapshots are uploaded
+	dir   string
+	files map[string]string // Files keyed by extension
+	owner string            // The discord id of the user whose backups the snapshot was uploaded to
+}
+
+// SnapshotWorld Copies the world a write or copy op of a .db or .fwl file is about to overwrite to a snapshot labelled
+// "prechange" next to it so the world can be restored if the new one turns out to be broken. Both files of the world
+// are copied since the op replaces its pair too. When UploadSnapshots is set the snapshot is also uploaded to the
+// user's backups in S3. Returns nil when the op doesn't write a world.
+func (s *S3Client) SnapshotWorld(fileManager *FileManager) (*WorldSnapshot, error) {
+	if (fileManager.Op != WRITE && fileManager.Op != COPY) || fileManager.Archive {
+		return nil, nil
+	}
+
+	name := filepath.Base(fileManager.FileDestinationPath)
+	ext := filepath.Ext(name)
+	if (ext != ".db" && ext != ".fwl") || IsWorldBackup(name) {
+		return nil, nil
+	}
+
+	world := strings.TrimSuffix(name, ext)
+	files, err := snapshotWorld(filepath.Dir(fileManager.FileDestinationPath), world, BackupLabelPreChange)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &WorldSnapshot{World: world, dir: filepath.Dir(fileManager.FileDestinationPath), files: files, owner: fileManager.DiscordId}
+	if len(files) == 0 {
+		log.Infof("world %s doesn't exist yet, nothing to snapshot before writing it", world)
+		return snapshot, nil
+	}
+
+	for _, ext := range []string{".db", ".fwl"} {
+		if files[ext] != "" {
+			snapshot.Files = append(snapshot.Files, files[ext])
+		}
+	}
+
+	if !s.UploadSnapshots {
+		return snapshot, nil
+	}
+
+	for _, path := range snapshot.Files {
+		result, err := s.UploadFile(path, BackupKey(fileManager.DiscordId, filepath.Base(path)))
+		if err != nil {
+			return snapshot, fmt.Errorf("failed to upload snapshot %s: %w", path, err)
+		}
+		snapshot.Keys = append(snapshot.Keys, result.Key)
+	}
+	return snapshot, nil
+}
+
+// Revert Puts the world back the way it was when the snapshot was taken. Files the world didn't have then are removed.
+func (w *WorldSnapshot) Revert() error {
+	return revertWorld(w.dir, w.World, []string{".db", ".fwl"}, w.files)
+}
+
+// DiscardSnapshot Removes the snapshot's files from the PVC and deletes any which were uploaded from the user's backups
+// in S3. It's used when the world was never replaced so the snapshot would only be a copy of the current world. Every
+// file is attempted and the errors of the ones which failed are returned.
+func (s *S3Client) DiscardSnapshot(snapshot *WorldSnapshot) error {
+	var errs []error
+	var uploaded []string
+	for _, file := range snapshot.Files {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, fmt.Errorf("failed to remove snapshot %s: %w", file, err))
+		}
+	}
+
+	for _, key := range snapshot.Keys {
+		uploaded = append(uploaded, path.Base(key))
+	}
+	if len(uploaded) > 0 {
+		errs = append(errs, s.DeleteBackups(snapshot.owner, uploaded))
+	}
+
+	if len(snapshot.Files) > 0 {
+		log.Infof("discarded snapshot of world %s", snapshot.World)
+	}
+	return errors.Join(errs...)
+}
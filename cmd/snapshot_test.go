@@ -0,0 +1,190 @@
+package cmd
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSnapshotWorld(t *testing.T) {
+	tests := []struct {
+		name        string
+		fileManager *FileManager
+		files       map[string]string
+		upload      bool
+		wantFiles   int
+		wantNil     bool // The op doesn't write a world
+	}{
+		{
+			name:        "write db",
+			fileManager: &FileManager{Op: WRITE, FileName: "MyWorld.db"},
+			files:       map[string]string{"MyWorld.db": "db", "MyWorld.fwl": "fwl"},
+			wantFiles:   2,
+		},
+		{
+			name:        "copy fwl uploaded to s3",
+			fileManager: &FileManager{Op: COPY, FileName: "MyWorld.fwl"},
+			files:       map[string]string{"MyWorld.db": "db", "MyWorld.fwl": "fwl"},
+			upload:      true,
+			wantFiles:   2,
+		},
+		{
+			name:        "world without its pair",
+			fileManager: &FileManager{Op: WRITE, FileName: "MyWorld.db"},
+			files:       map[string]string{"MyWorld.db": "db"},
+			wantFiles:   1,
+		},
+		{
+			name:        "new world",
+			fileManager: &FileManager{Op: WRITE, FileName: "MyWorld.db"},
+			files:       map[string]string{"Other.db": "db", "Other.fwl": "fwl"},
+		},
+		{
+			name:        "backup file",
+			fileManager: &FileManager{Op: WRITE, FileName: "MyWorld_backup_auto-20250101120000.db"},
+			files:       map[string]string{"MyWorld_backup_auto-20250101120000.db": "db"},
+			wantNil:     true,
+		},
+		{
+			name:        "delete",
+			fileManager: &FileManager{Op: DELETE, FileName: "MyWorld.db"},
+			files:       map[string]string{"MyWorld.db": "db", "MyWorld.fwl": "fwl"},
+			wantNil:     true,
+		},
+		{
+			name:        "config file",
+			fileManager: &FileManager{Op: WRITE, FileName: "BepInEx.cfg"},
+			files:       map[string]string{"BepInEx.cfg": "cfg"},
+			wantNil:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			makeTestWorlds(t, tt.files)
+			tt.fileManager.DiscordId = "123"
+			tt.fileManager.FileDestinationPath = filepath.Join(BACKUPS_DIR, tt.fileManager.FileName)
+			store := newFakeObjectStore()
+			s3Client := &S3Client{BucketName: "test-bucket", UploadSnapshots: tt.upload, client: store}
+
+			snapshot, err := s3Client.SnapshotWorld(tt.fileManager)
+			require.NoError(t, err)
+
+			entries, err := os.ReadDir(BACKUPS_DIR)
+			require.NoError(t, err)
+			assert.Len(t, entries, len(tt.files)+tt.wantFiles)
+			if tt.wantNil {
+				assert.Nil(t, snapshot)
+				return
+			}
+
+			require.NotNil(t, snapshot)
+			assert.Equal(t, "MyWorld", snapshot.World)
+			require.Len(t, snapshot.Files, tt.wantFiles)
+			for _, path := range snapshot.Files {
+				backup := ParseWorldBackup(filepath.Base(path))
+				require.NotNil(t, backup)
+				assert.Equal(t, BackupLabelPreChange, backup.Label)
+				assert.Equal(t, tt.files["MyWorld"+backup.Ext], readTestWorld(t, filepath.Base(path)))
+			}
+
+			if !tt.upload {
+				assert.Empty(t, snapshot.Keys)
+				assert.Empty(t, store.objects)
+				return
+			}
+
+			require.Len(t, snapshot.Keys, tt.wantFiles)
+			for i, key := range snapshot.Keys {
+				assert.Equal(t, BackupKey("123", filepath.Base(snapshot.Files[i])), key)
+				assert.Contains(t, store.objects, key)
+			}
+		})
+	}
+}
+
+func TestSnapshotWorld_Restorable(t *testing.T) {
+	makeTestWorlds(t, map[string]string{"MyWorld.db": "old db", "MyWorld.fwl": "old fwl"})
+	fileManager := &FileManager{Op: WRITE, FileName: "MyWorld.db", FileDestinationPath: filepath.Join(BACKUPS_DIR, "MyWorld.db")}
+	snapshot, err := (&S3Client{BucketName: "test-bucket", client: newFakeObjectStore()}).SnapshotWorld(fileManager)
+	require.NoError(t, err)
+
+	// The download replaces the world after which the snapshot restores the old one
+	require.NoError(t, os.WriteFile(filepath.Join(BACKUPS_DIR, "MyWorld.db"), []byte("new db"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(BACKUPS_DIR, "MyWorld.fwl"), []byte("new fwl"), 0644))
+
+	backup := ParseWorldBackup(filepath.Base(snapshot.Files[0]))
+	_, err = (&FileManager{Op: RESTORE, World: "MyWorld", Timestamp: backup.Timestamp}).Restore()
+	require.NoError(t, err)
+	assert.Equal(t, "old db", readTestWorld(t, "MyWorld.db"))
+	assert.Equal(t, "old fwl", readTestWorld(t, "MyWorld.fwl"))
+}
+
+func TestWorldSnapshot_Revert(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  map[string]string // The world's files after reverting, missing ones are removed
+	}{
+		{name: "existing world", files: map[string]string{"MyWorld.db": "old db", "MyWorld.fwl": "old fwl"}, want: map[string]string{".db": "old db", ".fwl": "old fwl"}},
+		{name: "world without its pair", files: map[string]string{"MyWorld.db": "old db"}, want: map[string]string{".db": "old db"}},
+		{name: "new world", files: map[string]string{}, want: map[string]string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			makeTestWorlds(t, tt.files)
+			fileManager := &FileManager{Op: WRITE, FileName: "MyWorld.fwl", FileDestinationPath: filepath.Join(BACKUPS_DIR, "MyWorld.fwl")}
+			snapshot, err := (&S3Client{BucketName: "test-bucket", client: newFakeObjectStore()}).SnapshotWorld(fileManager)
+			require.NoError(t, err)
+
+			require.NoError(t, os.WriteFile(filepath.Join(BACKUPS_DIR, "MyWorld.db"), []byte("new db"), 0644))
+			require.NoError(t, os.WriteFile(filepath.Join(BACKUPS_DIR, "MyWorld.fwl"), []byte("new fwl"), 0644))
+			require.NoError(t, snapshot.Revert())
+
+			for _, ext := range []string{".db", ".fwl"} {
+				if content, ok := tt.want[ext]; ok {
+					assert.Equal(t, content, readTestWorld(t, "MyWorld"+ext))
+				} else {
+					assert.NoFileExists(t, filepath.Join(BACKUPS_DIR, "MyWorld"+ext))
+				}
+			}
+		})
+	}
+}
+
+func TestS3Client_DiscardSnapshot(t *testing.T) {
+	tests := []struct {
+		name   string
+		files  map[string]string
+		upload bool
+	}{
+		{name: "existing world", files: map[string]string{"MyWorld.db": "db", "MyWorld.fwl": "fwl"}},
+		{name: "uploaded to s3", files: map[string]string{"MyWorld.db": "db", "MyWorld.fwl": "fwl"}, upload: true},
+		{name: "new world", files: map[string]string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			makeTestWorlds(t, tt.files)
+			fileManager := &FileManager{Op: WRITE, DiscordId: "123", FileName: "MyWorld.db", FileDestinationPath: filepath.Join(BACKUPS_DIR, "MyWorld.db")}
+			store := newFakeObjectStore()
+			s3Client := &S3Client{BucketName: "test-bucket", UploadSnapshots: tt.upload, client: store}
+			snapshot, err := s3Client.SnapshotWorld(fileManager)
+			require.NoError(t, err)
+
+			require.NoError(t, s3Client.DiscardSnapshot(snapshot))
+
+			// Only the world itself is left
+			entries, err := os.ReadDir(BACKUPS_DIR)
+			require.NoError(t, err)
+			assert.Len(t, entries, len(tt.files))
+			for name, content := range tt.files {
+				assert.Equal(t, content, readTestWorld(t, name))
+			}
+			assert.Empty(t, store.objects)
+		})
+	}
+}
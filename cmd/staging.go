@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+const (
+	// workDirName The hidden directory inside an install destination holding the manifests of installed archives.
+	workDirName = ".hearthhub"
+
+	// stagingDirSuffix Names the directory next to an install destination, i.e. plugins_staging, used to stage archives
+	// and hold the files they replace until an install has fully applied. It's kept out of the destination since
+	// BepInEx loads plugins from every directory below it, including ones left behind by a Job killed mid-install, and
+	// on the same volume so files can be moved in and out of place with an atomic rename.
+	stagingDirSuffix = "_staging"
+)
+
+// stagingRoot Returns the directory archives installed to destination are staged in.
+func stagingRoot(destination string) string {
+	destination = filepath.Clean(destination)
+	return filepath.Join(filepath.Dir(destination), filepath.Base(destination)+stagingDirSuffix)
+}
+
+// removeStaleStagingDirs Removes the staging and rollback directories left behind in dir by a Job which was killed
+// mid-install so their files are never picked up by the server.
+func removeStaleStagingDirs(dir string) {
+	for _, pattern := range []string{"staging-*", "rollback-*"} {
+		stale, _ := filepath.Glob(filepath.Join(dir, pattern))
+		for _, path := range stale {
+			log.Warnf("removing stale install directory: %s", path)
+			os.RemoveAll(path)
+		}
+	}
+}
+
+// installTransaction Moves staged files into place one at a time, keeping any file it replaces, so that an install
+// which fails part way can be rolled back to exactly the state the destination was in before it started.
+type installTransaction struct {
+	backupDir   string
+	createdDirs []string
+	installed   []installedFile
+}
+
+type installedFile struct {
+	target string
+	backup string // Where the file previously at target was moved or empty when target didn't exist
+}
+
+// beginInstall Starts a new install transaction keeping replaced files in a new backup directory under dir.
+func beginInstall(dir string) (*installTransaction, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	backupDir, err := os.MkdirTemp(dir, "rollback-")
+	if err != nil {
+		return nil, err
+	}
+
+	return &installTransaction{backupDir: backupDir}, nil
+}
+
+// mkdirAll Creates dir and any missing parents recording the ones which didn't already exist so they are removed on
+// rollback.
+func (t *installTransaction) mkdirAll(dir string) error {
+	var missing []string
+	for current := filepath.Clean(dir); ; current = filepath.Dir(current) {
+		info, err := os.Stat(current)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("%s exists and is not a directory", current)
+			}
+			break
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+		missing = append(missing, current)
+		if filepath.Dir(current) == current {
+			break
+		}
+	}
+
+	// Create parents first so a failure part way through still records every directory made so far
+	for _, d := range slices.Backward(missing) {
+		if err := os.Mkdir(d, 0755); err != nil && !os.IsExist(err) {
+			return err
+		}
+		t.createdDirs = append(t.createdDirs, d)
+	}
+	return nil
+}
+
+// install Moves the staged file to target. Any existing file at target is moved into the backup directory first so
+// that it can be restored.
+func (t *installTransaction) install(staged, target string) error {
+	if err := t.mkdirAll(filepath.Dir(target)); err != nil {
+		return err
+	}
+
+	record := installedFile{target: target}
+	info, err := os.Lstat(target)
+	if err == nil {
+		if info.IsDir() {
+			return fmt.Errorf("cannot install file over directory %s", target)
+		}
+
+		record.backup = filepath.Join(t.backupDir, fmt.Sprintf("%d", len(t.installed)))
+		if err := os.Rename(target, record.backup); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	// The record is kept even if the rename fails so the backup is restored on rollback
+	t.installed = append(t.installed, record)
+	return os.Rename(staged, target)
+}
+
+// remove Moves the file at target into the backup directory so that it is restored on rollback.
+func (t *installTransaction) remove(target string) error {
+	record := installedFile{
+		target: target,
+		backup: filepath.Join(t.backupDir, fmt.Sprintf("%d", len(t.installed))),
+	}
+	if err := os.Rename(target, record.backup); err != nil {
+		return err
+	}
+
+	t.installed = append(t.installed, record)
+	return nil
+}
+
+// rollback Undoes every install in reverse order restoring replaced files and removing directories the transaction
+// created. All errors are collected so one failure doesn't stop the rest of the destination being restored.
+func (t *installTransaction) rollback() error {
+	var errs []error
+	for _, file := range slices.Backward(t.installed) {
+		if err := os.Remove(file.target); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err)
+		}
+
+		if file.backup != "" {
+			if err := os.Rename(file.backup, file.target); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	for _, dir := range slices.Backward(t.createdDirs) {
+		if err := os.Remove(dir); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := os.RemoveAll(t.backupDir); err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(errs) > 0 {
+		log.Errorf("failed to fully roll back install: %v", errors.Join(errs...))
+	}
+	return errors.Join(errs...)
+}
+
+// commit Discards the files replaced by the install.
+func (t *installTransaction) commit() error {
+	return os.RemoveAll(t.backupDir)
+}
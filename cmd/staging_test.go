@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInstallTransaction_Commit(t *testing.T) {
+	destDir := t.TempDir()
+	createTestFiles(t, map[string]string{"Mod.dll": "old"}, destDir)
+
+	stagingDir := t.TempDir()
+	createTestFiles(t, map[string]string{"Mod.dll": "new", "Nested/New.dll": "added"}, stagingDir)
+
+	tx, err := beginInstall(stagingRoot(destDir))
+	require.NoError(t, err)
+	require.NoError(t, tx.install(filepath.Join(stagingDir, "Mod.dll"), filepath.Join(destDir, "Mod.dll")))
+	require.NoError(t, tx.install(filepath.Join(stagingDir, "Nested/New.dll"), filepath.Join(destDir, "Nested/New.dll")))
+	require.NoError(t, tx.commit())
+
+	assertFileContent(t, filepath.Join(destDir, "Mod.dll"), "new")
+	assertFileContent(t, filepath.Join(destDir, "Nested/New.dll"), "added")
+	_, err = os.Stat(tx.backupDir)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestInstallTransaction_Rollback(t *testing.T) {
+	destDir := t.TempDir()
+	createTestFiles(t, map[string]string{"Mod.dll": "old"}, destDir)
+
+	stagingDir := t.TempDir()
+	createTestFiles(t, map[string]string{"Mod.dll": "new", "a/b/c/New.dll": "added"}, stagingDir)
+
+	tx, err := beginInstall(stagingRoot(destDir))
+	require.NoError(t, err)
+	require.NoError(t, tx.install(filepath.Join(stagingDir, "Mod.dll"), filepath.Join(destDir, "Mod.dll")))
+	require.NoError(t, tx.install(filepath.Join(stagingDir, "a/b/c/New.dll"), filepath.Join(destDir, "a/b/c/New.dll")))
+	assert.Equal(t, []string{filepath.Join(destDir, "a"), filepath.Join(destDir, "a/b"), filepath.Join(destDir, "a/b/c")}, tx.createdDirs)
+
+	require.NoError(t, tx.rollback())
+
+	assertFileContent(t, filepath.Join(destDir, "Mod.dll"), "old")
+	_, err = os.Stat(filepath.Join(destDir, "a"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(tx.backupDir)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestInstallTransaction_RollbackRestoresRemovedFiles(t *testing.T) {
+	destDir := t.TempDir()
+	createTestFiles(t, map[string]string{"Mod/Legacy.dll": "legacy"}, destDir)
+
+	tx, err := beginInstall(stagingRoot(destDir))
+	require.NoError(t, err)
+	require.NoError(t, tx.remove(filepath.Join(destDir, "Mod/Legacy.dll")))
+	_, err = os.Stat(filepath.Join(destDir, "Mod/Legacy.dll"))
+	assert.True(t, os.IsNotExist(err))
+
+	require.NoError(t, tx.rollback())
+	assertFileContent(t, filepath.Join(destDir, "Mod/Legacy.dll"), "legacy")
+}
+
+func TestInstallTransaction_MkdirAllOverFile(t *testing.T) {
+	destDir := t.TempDir()
+	createTestFiles(t, map[string]string{"file": "not a dir"}, destDir)
+
+	tx, err := beginInstall(stagingRoot(destDir))
+	require.NoError(t, err)
+	assert.Error(t, tx.mkdirAll(filepath.Join(destDir, "file", "nested")))
+	assert.Empty(t, tx.createdDirs)
+	require.NoError(t, tx.rollback())
+}
@@ -0,0 +1,236 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+const (
+	// minUploadPartSize S3 rejects multipart uploads with parts (other than the last) smaller than 5 MB
+	minUploadPartSize = 5 * 1024 * 1024
+
+	// maxUploadParts The most parts S3 accepts in a single multipart upload.
+	maxUploadParts = 10000
+
+	// sha256MetadataKey The user metadata key the hex encoded SHA-256 of an uploaded file is stored under. Multipart
+	// uploads only get a composite checksum from S3 so this is the only checksum of the whole file.
+	sha256MetadataKey = "sha256"
+)
+
+// UploadResult Describes a file uploaded to S3 by UploadFile.
+type UploadResult struct {
+	Path   string
+	Key    string
+	Bytes  int64
+	SHA256 string // Hex encoded SHA-256 of the whole file
+	Parts  int    // The number of parts the file was uploaded in or 0 for a single PutObject
+}
+
+// UploadFile Uploads the file at path to key. Files larger than the client's part size are uploaded as a multipart
+// upload with up to Concurrency parts in flight at once, otherwise with a single PutObject. Every request carries a
+// SHA-256 checksum which S3 verifies and stores with the object, and the SHA-256 of the whole file is stored in the
+// object's metadata. Each request is retried according to the client's retry policy and a multipart upload which fails
+// is aborted so no parts are left behind.
+func (s *S3Client) UploadFile(path, key string) (*UploadResult, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return nil, err
+	}
+
+	result := &UploadResult{Path: path, Key: key, Bytes: info.Size(), SHA256: hex.EncodeToString(hash.Sum(nil))}
+	ctx := context.Background()
+	partSize := s.uploadPartSize(info.Size())
+	if partSize > 0 && info.Size() > partSize {
+		result.Parts, err = s.uploadParts(ctx, file, info.Size(), partSize, key, result.SHA256)
+	} else {
+		err = s.uploadObject(ctx, file, info.Size(), key, hash.Sum(nil))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("uploaded %d bytes from %s to s3://%s/%s, sha256: %s", result.Bytes, path, s.BucketName, key, result.SHA256)
+	return result, nil
+}
+
+// uploadPartSize Returns the part size to upload a file of the given size with. The client's part size is raised to
+// the smallest part S3 accepts and grown when the file would otherwise need more parts than S3 allows.
+func (s *S3Client) uploadPartSize(size int64) int64 {
+	if s.PartSize <= 0 {
+		return 0
+	}
+	return max(s.PartSize, minUploadPartSize, (size+maxUploadParts-1)/maxUploadParts)
+}
+
+// uploadObject Uploads the whole file with a single PutObject.
+func (s *S3Client) uploadObject(ctx context.Context, file *os.File, size int64, key string, sum []byte) error {
+	return s.Retry.Do(ctx, fmt.Sprintf("upload s3://%s/%s", s.BucketName, key), func() error {
+		_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:            aws.String(s.BucketName),
+			Key:               aws.String(key),
+			Body:              io.NewSectionReader(file, 0, size),
+			ContentLength:     aws.Int64(size),
+			ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+			ChecksumSHA256:    aws.String(base64.StdEncoding.EncodeToString(sum)),
+			Metadata:          map[string]string{sha256MetadataKey: hex.EncodeToString(sum)},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to put object s3://%v/%v err: %w", s.BucketName, key, err)
+		}
+		return nil
+	})
+}
+
+// uploadParts Uploads the file as a multipart upload of partSize parts returning the number of parts uploaded.
+func (s *S3Client) uploadParts(ctx context.Context, file *os.File, size, partSize int64, key, sum string) (int, error) {
+	var upload *s3.CreateMultipartUploadOutput
+	err := s.Retry.Do(ctx, fmt.Sprintf("create multipart upload s3://%s/%s", s.BucketName, key), func() error {
+		var err error
+		upload, err = s.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+			Bucket:            aws.String(s.BucketName),
+			Key:               aws.String(key),
+			ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+			Metadata:          map[string]string{sha256MetadataKey: sum},
+		})
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to create multipart upload s3://%v/%v err: %w", s.BucketName, key, err)
+	}
+
+	log.Infof("uploading %s to s3://%s/%s in %d byte parts with concurrency: %d", file.Name(), s.BucketName, key, partSize, s.Concurrency)
+	parts, err := s.uploadPartsConcurrently(ctx, file, size, partSize, key, upload.UploadId)
+	if err == nil {
+		err = s.Retry.Do(ctx, fmt.Sprintf("complete multipart upload s3://%s/%s", s.BucketName, key), func() error {
+			_, err := s.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+				Bucket:          aws.String(s.BucketName),
+				Key:             aws.String(key),
+				UploadId:        upload.UploadId,
+				MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+			})
+			return err
+		})
+	}
+
+	if err != nil {
+		// A fresh context is used so the abort is still sent when the upload failed because its context was cancelled
+		_, abortErr := s.client.AbortMultipartUpload(context.Background(), &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(s.BucketName),
+			Key:      aws.String(key),
+			UploadId: upload.UploadId,
+		})
+		if abortErr != nil {
+			log.Errorf("failed to abort multipart upload %s of s3://%s/%s: %v", aws.ToString(upload.UploadId), s.BucketName, key, abortErr)
+		}
+		return 0, fmt.Errorf("failed to upload s3://%v/%v err: %w", s.BucketName, key, err)
+	}
+	return len(parts), nil
+}
+
+// uploadPartsConcurrently Uploads every part of the file with up to Concurrency parts in flight returning the
+// completed parts in order.
+func (s *S3Client) uploadPartsConcurrently(ctx context.Context, file *os.File, size, partSize int64, key string, uploadId *string) ([]types.CompletedPart, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	concurrency := max(s.Concurrency, 1)
+	numbers := make(chan int32)
+	errs := make(chan error, concurrency)
+	var mu sync.Mutex
+	var parts []types.CompletedPart
+	var wg sync.WaitGroup
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for number := range numbers {
+				start := int64(number-1) * partSize
+				part, err := s.uploadPart(ctx, io.NewSectionReader(file, start, min(partSize, size-start)), key, uploadId, number)
+				if err != nil {
+					errs <- err
+					cancel()
+					return
+				}
+
+				mu.Lock()
+				parts = append(parts, *part)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	go func() {
+		defer close(numbers)
+		for number := int32(1); int64(number-1)*partSize < size; number++ {
+			select {
+			case numbers <- number:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		return nil, err
+	}
+
+	sort.Slice(parts, func(i, j int) bool {
+		return aws.ToInt32(parts[i].PartNumber) < aws.ToInt32(parts[j].PartNumber)
+	})
+	return parts, nil
+}
+
+// uploadPart Uploads a single part with its SHA-256 checksum.
+func (s *S3Client) uploadPart(ctx context.Context, part *io.SectionReader, key string, uploadId *string, number int32) (*types.CompletedPart, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, part); err != nil {
+		return nil, err
+	}
+	checksum := base64.StdEncoding.EncodeToString(hash.Sum(nil))
+
+	var output *s3.UploadPartOutput
+	err := s.Retry.Do(ctx, fmt.Sprintf("upload part %d of s3://%s/%s", number, s.BucketName, key), func() error {
+		var err error
+		output, err = s.client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:            aws.String(s.BucketName),
+			Key:               aws.String(key),
+			UploadId:          uploadId,
+			PartNumber:        aws.Int32(number),
+			Body:              io.NewSectionReader(part, 0, part.Size()),
+			ContentLength:     aws.Int64(part.Size()),
+			ChecksumAlgorithm: types.ChecksumAlgorithmSha256,
+			ChecksumSHA256:    aws.String(checksum),
+		})
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload part %d of s3://%v/%v err: %w", number, s.BucketName, key, err)
+	}
+
+	return &types.CompletedPart{ETag: output.ETag, PartNumber: aws.Int32(number), ChecksumSHA256: aws.String(checksum)}, nil
+}
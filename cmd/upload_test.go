@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestUploadFile Writes a file of the given size with varying contents so misordered parts are caught.
+func writeTestUploadFile(t *testing.T, size int) (string, []byte) {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	path := filepath.Join(t.TempDir(), "world.db")
+	require.NoError(t, os.WriteFile(path, data, 0644))
+	return path, data
+}
+
+func TestUploadFile(t *testing.T) {
+	tests := []struct {
+		name        string
+		size        int
+		partSize    int64
+		concurrency int
+		wantParts   int
+	}{
+		{name: "smaller than a part", size: 1000, partSize: minUploadPartSize, concurrency: 4, wantParts: 0},
+		{name: "multipart", size: 2*minUploadPartSize + 1000, partSize: minUploadPartSize, concurrency: 4, wantParts: 3},
+		{name: "multipart without concurrency", size: 2*minUploadPartSize + 1000, partSize: minUploadPartSize, concurrency: 1, wantParts: 3},
+		{name: "part size raised to the minimum", size: minUploadPartSize + 1000, partSize: 1024, concurrency: 4, wantParts: 2},
+		{name: "no part size", size: minUploadPartSize + 1000, partSize: 0, concurrency: 4, wantParts: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, data := writeTestUploadFile(t, tt.size)
+			store := newFakeObjectStore()
+			s3Client := &S3Client{BucketName: "test-bucket", PartSize: tt.partSize, Concurrency: tt.concurrency, client: store}
+
+			result, err := s3Client.UploadFile(path, "backups/world.db")
+			require.NoError(t, err)
+
+			sum := sha256.Sum256(data)
+			assert.Equal(t, hex.EncodeToString(sum[:]), result.SHA256)
+			assert.Equal(t, int64(tt.size), result.Bytes)
+			assert.Equal(t, tt.wantParts, result.Parts)
+			assert.Equal(t, data, store.objects["backups/world.db"])
+			assert.Equal(t, result.SHA256, store.metadata["backups/world.db"][sha256MetadataKey])
+		})
+	}
+}
+
+func TestUploadFile_PartFailureAbortsUpload(t *testing.T) {
+	path, _ := writeTestUploadFile(t, 2*minUploadPartSize+1000)
+	store := newFakeObjectStore()
+	store.failPart = 2
+	s3Client := &S3Client{BucketName: "test-bucket", PartSize: minUploadPartSize, Concurrency: 2, Retry: makeTestRetryPolicy(2, nil), client: store}
+
+	_, err := s3Client.UploadFile(path, "backups/world.db")
+	require.Error(t, err)
+
+	_, ok := store.objects["backups/world.db"]
+	assert.False(t, ok)
+	require.Len(t, store.uploads, 1)
+	for _, upload := range store.uploads {
+		assert.True(t, upload.aborted)
+		assert.False(t, upload.completed)
+	}
+}
+
+func TestUploadFile_MissingFile(t *testing.T) {
+	s3Client := &S3Client{BucketName: "test-bucket", client: newFakeObjectStore()}
+	_, err := s3Client.UploadFile(filepath.Join(t.TempDir(), "missing.db"), "backups/missing.db")
+	assert.True(t, os.IsNotExist(err))
+}
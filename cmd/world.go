@@ -0,0 +1,204 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// maxWorldMetadataSize Real .fwl files are a few hundred bytes, anything claiming to be larger isn't one.
+	maxWorldMetadataSize = 1024 * 1024
+
+	// worldGenVersionSince The first world version which saves the world generator version.
+	worldGenVersionSince = 26
+)
+
+// WorldMetadata The metadata Valheim saves about a world in its .fwl file.
+type WorldMetadata struct {
+	Version         int32  `json:"version"` // The version of the world save format
+	Name            string `json:"name"`
+	SeedName        string `json:"seed_name"`
+	Seed            int32  `json:"seed"`
+	UID             int64  `json:"uid"`
+	WorldGenVersion int32  `json:"world_gen_version"` // 0 for worlds saved before the version was recorded
+}
+
+// ReadWorldMetadata Reads the metadata of a world from its .fwl file.
+func ReadWorldMetadata(path string) (*WorldMetadata, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	metadata, err := parseWorldMetadata(file)
+	if err != nil {
+		return nil, fmt.Errorf("invalid world file %s: %w", path, err)
+	}
+	return metadata, nil
+}
+
+// parseWorldMetadata Parses a .fwl file. The file is a little endian int32 length followed by a package of that length
+// holding the world version, name, seed name, seed, UID and, from version 26, the world generator version. Strings are
+// prefixed with their length in bytes encoded 7 bits at a time like .NET's BinaryWriter. Anything Valheim saves after
+// the world generator version is ignored.
+func parseWorldMetadata(r io.Reader) (*WorldMetadata, error) {
+	var length int32
+	if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+		return nil, fmt.Errorf("failed to read length: %w", err)
+	}
+	if length <= 0 || length > maxWorldMetadataSize {
+		return nil, fmt.Errorf("invalid length %d", length)
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, fmt.Errorf("failed to read %d bytes: %w", length, err)
+	}
+
+	pkg := bytes.NewReader(data)
+	metadata := &WorldMetadata{}
+	if err := binary.Read(pkg, binary.LittleEndian, &metadata.Version); err != nil {
+		return nil, fmt.Errorf("failed to read version: %w", err)
+	}
+
+	var err error
+	if metadata.Name, err = readWorldString(pkg); err != nil {
+		return nil, fmt.Errorf("failed to read name: %w", err)
+	}
+	if metadata.SeedName, err = readWorldString(pkg); err != nil {
+		return nil, fmt.Errorf("failed to read seed name: %w", err)
+	}
+	if err := binary.Read(pkg, binary.LittleEndian, &metadata.Seed); err != nil {
+		return nil, fmt.Errorf("failed to read seed: %w", err)
+	}
+	if err := binary.Read(pkg, binary.LittleEndian, &metadata.UID); err != nil {
+		return nil, fmt.Errorf("failed to read uid: %w", err)
+	}
+
+	if metadata.Version >= worldGenVersionSince {
+		if err := binary.Read(pkg, binary.LittleEndian, &metadata.WorldGenVersion); err != nil {
+			return nil, fmt.Errorf("failed to read world gen version: %w", err)
+		}
+	}
+	return metadata, nil
+}
+
+// readWorldString Reads a string prefixed with its 7 bit encoded length.
+func readWorldString(r *bytes.Reader) (string, error) {
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", err
+	}
+	if length > uint64(r.Len()) {
+		return "", fmt.Errorf("string length %d is longer than the %d bytes left", length, r.Len())
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return "", err
+	}
+	if !utf8.Valid(data) {
+		return "", errors.New("string is not valid UTF-8")
+	}
+	return string(data), nil
+}
+
+// ValidateWorld Checks the .fwl file of the world the .db or .fwl file at path belongs to can be read and names the same
+// world as its files so Valheim loads the pair together. Backups name the world they were taken of. Returns the
+// world's metadata.
+func ValidateWorld(path string) (*WorldMetadata, error) {
+	name := filepath.Base(path)
+	world := strings.TrimSuffix(name, filepath.Ext(name))
+	if backup := ParseWorldBackup(name); backup != nil {
+		world = backup.World
+	}
+
+	fwl := strings.TrimSuffix(path, filepath.Ext(path)) + ".fwl"
+	metadata, err := ReadWorldMetadata(fwl)
+	if err != nil {
+		return nil, err
+	}
+
+	if metadata.Name != world {
+		return nil, fmt.Errorf("world file %s is for world %q, not %q. The .db and .fwl files of a world must be named after it", fwl, metadata.Name, world)
+	}
+	return metadata, nil
+}
+
+// StagedWorld Both files of a world downloaded next to the world but not yet moved into place.
+type StagedWorld struct {
+	Download *DownloadResult // The download of the file the op was given, its pair is downloaded alongside it
+	dir      string
+	files    []string // The staged files with the world's file names
+	targets  []string // Where each staged file is moved to
+}
+
+// StageWorld Downloads a .db or .fwl file along with its pair into a staging directory next to the world so the pair
+// can be validated before the world is touched. Either file failing to download fails the whole world. Staging
+// directories left behind by a Job which was killed are removed first.
+func (s *S3Client) StageWorld(fileManager *FileManager) (*StagedWorld, error) {
+	pair := worldPair(fileManager)
+	if pair == nil {
+		return nil, fmt.Errorf("%s is not a world file", fileManager.Prefix)
+	}
+
+	dir := filepath.Dir(fileManager.FileDestinationPath)
+	stale, _ := filepath.Glob(filepath.Join(dir, ".staging-*"))
+	for _, path := range stale {
+		log.Warnf("removing stale world staging directory: %s", path)
+		os.RemoveAll(path)
+	}
+
+	stagingDir, err := os.MkdirTemp(dir, ".staging-")
+	if err != nil {
+		return nil, err
+	}
+
+	staged := &StagedWorld{dir: stagingDir}
+	for i, file := range []*FileManager{fileManager, pair} {
+		download := *file
+		download.FileDestinationPath = filepath.Join(stagingDir, filepath.Base(file.FileDestinationPath))
+		result, err := s.DownloadFile(&download)
+		if err != nil {
+			staged.Close()
+			return nil, fmt.Errorf("failed to download world file %s: %w", file.Prefix, err)
+		}
+
+		if i == 0 {
+			staged.Download = result
+		}
+		staged.files = append(staged.files, download.FileDestinationPath)
+		staged.targets = append(staged.targets, file.FileDestinationPath)
+	}
+	return staged, nil
+}
+
+// Validate Checks the staged pair with ValidateWorld returning the world's metadata.
+func (w *StagedWorld) Validate() (*WorldMetadata, error) {
+	return ValidateWorld(w.files[0])
+}
+
+// Install Moves the staged files over the world. A failure part way leaves the world half replaced so the caller
+// reverts it from its snapshot.
+func (w *StagedWorld) Install() error {
+	for i, file := range w.files {
+		if err := os.Rename(file, w.targets[i]); err != nil {
+			return fmt.Errorf("failed to move %s into place: %w", w.targets[i], err)
+		}
+	}
+	return nil
+}
+
+// Close Removes the staging directory along with anything still staged in it.
+func (w *StagedWorld) Close() error {
+	return os.RemoveAll(w.dir)
+}
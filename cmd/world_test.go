@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// makeTestFwl Encodes world metadata the way Valheim saves a .fwl file followed by any extra fields newer versions save.
+func makeTestFwl(metadata WorldMetadata, extra ...byte) []byte {
+	var pkg []byte
+	pkg = binary.LittleEndian.AppendUint32(pkg, uint32(metadata.Version))
+	for _, s := range []string{metadata.Name, metadata.SeedName} {
+		pkg = binary.AppendUvarint(pkg, uint64(len(s)))
+		pkg = append(pkg, s...)
+	}
+	pkg = binary.LittleEndian.AppendUint32(pkg, uint32(metadata.Seed))
+	pkg = binary.LittleEndian.AppendUint64(pkg, uint64(metadata.UID))
+	if metadata.Version >= worldGenVersionSince {
+		pkg = binary.LittleEndian.AppendUint32(pkg, uint32(metadata.WorldGenVersion))
+	}
+	pkg = append(pkg, extra...)
+	return append(binary.LittleEndian.AppendUint32(nil, uint32(len(pkg))), pkg...)
+}
+
+func TestParseWorldMetadata(t *testing.T) {
+	current := WorldMetadata{Version: 34, Name: "MyWorld", SeedName: "hQ9dL0KxyZ", Seed: -1928374655, UID: 1234567890123456789, WorldGenVersion: 2}
+	old := WorldMetadata{Version: 25, Name: "Old World", SeedName: "abc", Seed: 42, UID: 7}
+	long := WorldMetadata{Version: 34, Name: string(bytes.Repeat([]byte("n"), 200)), SeedName: "seed", WorldGenVersion: 1}
+	valid := makeTestFwl(current)
+
+	// A version 34 world saved without the world gen version it should have
+	missingWorldGen := makeTestFwl(WorldMetadata{Version: 25, Name: "MyWorld"})
+	binary.LittleEndian.PutUint32(missingWorldGen[4:], 34)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    *WorldMetadata
+		wantErr bool
+	}{
+		{name: "current version", data: makeTestFwl(current, 1, 0, 0, 0, 0), want: &current},
+		{name: "before world gen version", data: makeTestFwl(old), want: &old},
+		{name: "name with a two byte length", data: makeTestFwl(long), want: &long},
+		{name: "empty", data: nil, wantErr: true},
+		{name: "zero length", data: []byte{0, 0, 0, 0}, wantErr: true},
+		{name: "negative length", data: []byte{0xff, 0xff, 0xff, 0xff}, wantErr: true},
+		{name: "truncated", data: valid[:len(valid)-3], wantErr: true},
+		{name: "missing world gen version", data: missingWorldGen, wantErr: true},
+		{name: "string longer than the file", data: []byte{6, 0, 0, 0, 34, 0, 0, 0, 100, 'M'}, wantErr: true},
+		{name: "invalid utf-8", data: []byte{7, 0, 0, 0, 34, 0, 0, 0, 2, 0xff, 0xfe}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseWorldMetadata(bytes.NewReader(tt.data))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestValidateWorld(t *testing.T) {
+	makeTestWorlds(t, map[string]string{
+		"MyWorld.db":                             "db",
+		"MyWorld.fwl":                            string(makeTestFwl(WorldMetadata{Version: 34, Name: "MyWorld", SeedName: "seed"})),
+		"Renamed.db":                             "db",
+		"Renamed.fwl":                            string(makeTestFwl(WorldMetadata{Version: 34, Name: "MyWorld", SeedName: "seed"})),
+		"Broken.fwl":                             "not a world",
+		"MyWorld_backup_auto-20250101120000.fwl": string(makeTestFwl(WorldMetadata{Version: 34, Name: "MyWorld", SeedName: "seed"})),
+	})
+
+	tests := []struct {
+		name    string
+		file    string
+		wantErr bool
+	}{
+		{name: "db", file: "MyWorld.db"},
+		{name: "fwl", file: "MyWorld.fwl"},
+		{name: "backup", file: "MyWorld_backup_auto-20250101120000.db"},
+		{name: "names don't match", file: "Renamed.db", wantErr: true},
+		{name: "invalid fwl", file: "Broken.db", wantErr: true},
+		{name: "missing fwl", file: "Missing.db", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metadata, err := ValidateWorld(filepath.Join(BACKUPS_DIR, tt.file))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "MyWorld", metadata.Name)
+			assert.Equal(t, "seed", metadata.SeedName)
+		})
+	}
+}
+
+func TestReadWorldMetadata_Missing(t *testing.T) {
+	_, err := ReadWorldMetadata(filepath.Join(t.TempDir(), "MyWorld.fwl"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestStageWorld(t *testing.T) {
+	valid := string(makeTestFwl(WorldMetadata{Version: 34, Name: "MyWorld", SeedName: "new"}))
+	tests := []struct {
+		name        string
+		objects     map[string]string
+		wantErr     bool
+		wantInvalid bool
+	}{
+		{name: "valid pair", objects: map[string]string{"worlds/MyWorld.db": "new db", "worlds/MyWorld.fwl": valid}},
+		{name: "missing pair", objects: map[string]string{"worlds/MyWorld.db": "new db"}, wantErr: true},
+		{
+			name:        "pair of another world",
+			objects:     map[string]string{"worlds/MyWorld.db": "new db", "worlds/MyWorld.fwl": string(makeTestFwl(WorldMetadata{Version: 34, Name: "Other"}))},
+			wantInvalid: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			makeTestWorlds(t, map[string]string{"MyWorld.db": "old db", "MyWorld.fwl": "old fwl"})
+			store := newFakeObjectStore()
+			for key, data := range tt.objects {
+				store.put(key, []byte(data))
+			}
+			s3Client := &S3Client{BucketName: "test-bucket", client: store}
+			fileManager := &FileManager{Op: WRITE, Prefix: "worlds/MyWorld.db", FileName: "MyWorld.db", FileDestinationPath: filepath.Join(BACKUPS_DIR, "MyWorld.db")}
+
+			staged, err := s3Client.StageWorld(fileManager)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, int64(len("new db")), staged.Download.Bytes)
+
+				_, err = staged.Validate()
+				if tt.wantInvalid {
+					assert.Error(t, err)
+				} else {
+					require.NoError(t, err)
+					require.NoError(t, staged.Install())
+				}
+				require.NoError(t, staged.Close())
+			}
+
+			// Nothing is staged once done and the world is only replaced by a valid pair
+			entries, err := os.ReadDir(BACKUPS_DIR)
+			require.NoError(t, err)
+			assert.Len(t, entries, 2)
+			if tt.wantErr || tt.wantInvalid {
+				assert.Equal(t, "old db", readTestWorld(t, "MyWorld.db"))
+				assert.Equal(t, "old fwl", readTestWorld(t, "MyWorld.fwl"))
+				return
+			}
+			assert.Equal(t, "new db", readTestWorld(t, "MyWorld.db"))
+			assert.Equal(t, valid, readTestWorld(t, "MyWorld.fwl"))
+		})
+	}
+}
@@ -2,8 +2,10 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/cbartram/hearthhub-common/model"
 	"github.com/cbartram/hearthhub-common/service"
@@ -37,61 +39,187 @@ func main() {
 	log.SetOutput(os.Stdout)
 	log.SetLevel(logLevel)
 
-	cfg, err := config.LoadDefaultConfig(ctx)
-	if err != nil {
-		log.Fatalf("unable to load AWS SDK config: %v", err)
-	}
-
 	flagSet := flag.NewFlagSet("file-manager", flag.ExitOnError)
 	fileManager, err := cmd.MakeFileManager(flagSet, os.Args[1:])
 	if err != nil {
 		log.Fatalf("unable to make file manager: %v", err)
 	}
-	hearthhubClient := cmd.MakeHearthHubClient(os.Getenv("API_BASE_URL"))
+	retry := cmd.MakeRetryPolicy()
+
+	// RabbitMQ is connected first so every failure after this point can be reported back to the user.
+	rabbit, err := cmd.MakeRabbitMQService(retry)
+	if err != nil {
+		log.Fatalf("failed to make rabbitmq service: %v", err)
+	}
+
+	publishProgress(rabbit, fileManager, &cmd.InstallStarted{EventMetadata: cmd.MakeEventMetadata(fileManager)})
 
-	err = hearthhubClient.ScaleDeployment(fileManager, 0)
+	cfg, err := config.LoadDefaultConfig(ctx)
 	if err != nil {
-		log.Fatalf("failed to scale valheim server deployment: %v", err)
+		fail(rabbit, fileManager, "load-config", fmt.Errorf("unable to load AWS SDK config: %w", err))
+	}
+
+	// Pruning the download cache doesn't touch any files the server uses so the server is left running.
+	if fileManager.Op == cmd.PRUNE_CACHE {
+		pruneCache(rabbit, fileManager, cmd.MakeS3Client(cfg, retry))
+		return
+	}
+
+	// Pruning only removes old backups which the server never reads or writes again so it's left running too.
+	if fileManager.Op == cmd.PRUNE {
+		prune(rabbit, fileManager, cfg, cmd.MakeS3Client(cfg, retry))
+		return
 	}
 
-	log.Infof("sleeping for 7 seconds to allow server to terminate")
-	time.Sleep(7 * time.Second)
+	hearthhubClient := cmd.MakeHearthHubClient(os.Getenv("API_BASE_URL"), retry)
+
+	running, err := hearthhubClient.StopServer(fileManager)
+	if err != nil {
+		fail(rabbit, fileManager, "scale-down", fmt.Errorf("failed to scale valheim server deployment: %w", err))
+	}
+
+	if running {
+		publishProgress(rabbit, fileManager, makeProgress(fileManager, "scale-down", "valheim server scaled down"))
+		err = hearthhubClient.WaitForServerStop(fileManager)
+		if err != nil {
+			fail(rabbit, fileManager, "wait-for-stop", fmt.Errorf("failed to wait for valheim server to stop: %w", err))
+		}
+		publishProgress(rabbit, fileManager, makeProgress(fileManager, "wait-for-stop", "valheim server stopped"))
+	} else {
+		publishProgress(rabbit, fileManager, makeProgress(fileManager, "scale-down", "no valheim server running"))
+	}
 
 	if !fileManager.DirExists(modPath) || !fileManager.DirExists(configPath) || !fileManager.DirExists(backupsPath) {
-		log.Fatal("required conf, backup, or mod directory does not exist")
+		fail(rabbit, fileManager, "check-directories", errors.New("required conf, backup, or mod directory does not exist"))
 	}
 
 	db := model.Connect()
-	s3Client := cmd.MakeS3Client(cfg)
-	err = s3Client.DownloadFile(fileManager)
-	if err != nil {
-		log.Fatalf("failed to download file: %v", err)
+	s3Client := cmd.MakeS3Client(cfg, retry)
+	if fileManager.Op == cmd.BACKUP {
+		backup(rabbit, fileManager, s3Client)
+		return
 	}
-	err = cmd.SyncWorldFiles(s3Client, fileManager)
-	if err != nil {
-		log.Errorf("failed to sync world files: %v", err)
+
+	// Restores swap in a backup which is already on the PVC so there's nothing to download.
+	download := &cmd.DownloadResult{}
+	var worldMetadata *cmd.WorldMetadata
+	if fileManager.Op != cmd.RESTORE {
+		// The world is snapshotted before the download replaces it. The snapshot is recorded as a backup file along
+		// with the world's other backups below so it can be restored later.
+		snapshot, err := s3Client.SnapshotWorld(fileManager)
+		if err != nil {
+			discardSnapshot(s3Client, snapshot)
+			fail(rabbit, fileManager, "snapshot", fmt.Errorf("failed to snapshot world before overwriting it: %w", err))
+		}
+		if snapshot != nil && len(snapshot.Files) > 0 {
+			publishProgress(rabbit, fileManager, makeProgress(fileManager, "snapshot", fmt.Sprintf("snapshotted world %s to %d files", snapshot.World, len(snapshot.Files))))
+		}
+
+		// A world is downloaded as a pair and validated before it replaces the world so Valheim never loads a world whose
+		// files don't belong together.
+		if snapshot != nil {
+			staged, err := s3Client.StageWorld(fileManager)
+			if err != nil {
+				discardSnapshot(s3Client, snapshot)
+				fail(rabbit, fileManager, "download", fmt.Errorf("failed to download world: %w", err))
+			}
+			download = staged.Download
+			publishProgress(rabbit, fileManager, makeProgress(fileManager, "download", fmt.Sprintf("downloaded %d bytes", download.Bytes)))
+
+			worldMetadata, err = staged.Validate()
+			if err != nil {
+				staged.Close()
+				discardSnapshot(s3Client, snapshot)
+				fail(rabbit, fileManager, "validate-world", fmt.Errorf("invalid world: %w", err))
+			}
+
+			err = staged.Install()
+			staged.Close()
+			if err != nil {
+				if revertErr := snapshot.Revert(); revertErr != nil {
+					log.Errorf("failed to revert world %s: %v", snapshot.World, revertErr)
+				} else {
+					discardSnapshot(s3Client, snapshot)
+				}
+				fail(rabbit, fileManager, "install-world", err)
+			}
+		} else {
+			download, err = s3Client.DownloadFile(fileManager)
+			if err != nil {
+				fail(rabbit, fileManager, "download", fmt.Errorf("failed to download file: %w", err))
+			}
+			publishProgress(rabbit, fileManager, makeProgress(fileManager, "download", fmt.Sprintf("downloaded %d bytes", download.Bytes)))
+
+			err = cmd.SyncWorldFiles(s3Client, fileManager)
+			if err != nil {
+				fail(rabbit, fileManager, "sync-world", fmt.Errorf("failed to sync world files: %w", err))
+			}
+		}
+	}
+
+	// Metadata is best effort, a mod installed without it is still installed.
+	var metadata *cmd.PackageMetadata
+	var dependencies []*cmd.ResolvedPackage
+	var resolver *cmd.DependencyResolver
+	if fileManager.Archive && fileManager.Op == cmd.WRITE {
+		metadata, err = fileManager.ArchiveHandler.ReadPackageMetadata()
+		if err != nil {
+			log.Errorf("failed to read package metadata: %v", err)
+		}
+
+		// Dependencies are installed first so the mod never loads without them.
+		if metadata != nil && len(metadata.Dependencies) > 0 {
+			resolver = cmd.MakeDependencyResolver(s3Client, fileManager)
+			dependencies, err = resolver.Resolve(metadata)
+			if err != nil {
+				fail(rabbit, fileManager, "resolve-dependencies", err)
+			}
+
+			err = resolver.Install(dependencies)
+			if err != nil {
+				fail(rabbit, fileManager, "install-dependencies", err)
+			}
+			publishProgress(rabbit, fileManager, makeProgress(fileManager, "install-dependencies", fmt.Sprintf("installed %d dependencies", len(dependencies))))
+		}
 	}
 
 	err = fileManager.DoOperation()
 	if err != nil {
-		log.Fatalf("failed to unpack or remove files: %v", err)
+		// The dependencies installed for the mod are only wanted alongside it
+		if len(dependencies) > 0 {
+			if rollbackErr := resolver.Rollback(dependencies); rollbackErr != nil {
+				log.Errorf("failed to remove dependencies installed for %s: %v", fileManager.FileName, rollbackErr)
+			}
+		}
+		fail(rabbit, fileManager, "operation", fmt.Errorf("failed to unpack or remove files: %w", err))
 	}
 
-	rabbit, err := cmd.MakeRabbitMQService()
-	if err != nil {
-		log.Fatalf("failed to make rabbitmq service: %v", err)
+	if fileManager.Op == cmd.RESTORE {
+		worldMetadata, err = cmd.ValidateWorld(fileManager.FileDestinationPath)
+		if err != nil {
+			log.Warnf("failed to read the metadata of restored world %s: %v", fileManager.World, err)
+		}
+	}
+
+	succeeded := &cmd.InstallSucceeded{
+		EventMetadata: cmd.MakeEventMetadata(fileManager),
+		Bytes:         download.Bytes,
+		SHA256:        download.SHA256,
+		Verified:      download.Verified,
+		World:         worldMetadata,
+	}
+	if metadata != nil {
+		succeeded.Package = metadata.Name
+		succeeded.Version = metadata.VersionNumber
+	}
+	for _, dependency := range dependencies {
+		succeeded.Dependencies = append(succeeded.Dependencies, dependency.Dependency.String())
 	}
 
-	// TODO: In the future consider publishing failure messages as well.
-	err = rabbit.PublishMessage(&cmd.Message{
-		Type:      "PreStop",
-		Body:      fmt.Sprintf(`{"containerName": "%s", "operation": "%s", "containerType": "file-install"}`, os.Getenv("HOSTNAME"), fileManager.Op),
-		DiscordId: fileManager.DiscordId,
-	})
 	cognito := service.MakeCognitoService(cfg)
 	_, err = cognito.AuthUser(context.Background(), &fileManager.RefreshToken, &fileManager.DiscordId, db)
 	if err != nil {
-		log.Fatalf("failed to authenticate user: %v", err)
+		fail(rabbit, fileManager, "authenticate", fmt.Errorf("failed to authenticate user: %w", err))
 	}
 
 	var user model.User
@@ -104,58 +232,57 @@ func main() {
 	}
 
 	if fileManager.Archive {
-		user.ModFiles = append(user.ModFiles, model.ModFile{
-			BaseFile: model.BaseFile{
-				UserID:    user.ID,
-				Size:      size,
-				FileName:  fileManager.FileName,
-				Installed: fileManager.Op == cmd.WRITE || fileManager.Op == cmd.COPY,
-				S3Key:     fileManager.Prefix,
-			},
-			UpVotes:            0,
-			Downloads:          0,
-			OriginalUploadDate: time.Now(),
-			LatestUploadDate:   time.Now(),
-			Creator:            "",
-			HeroImage:          "",
-			Description:        "",
-		})
+		installed := fileManager.Op == cmd.WRITE || fileManager.Op == cmd.COPY || fileManager.Op == cmd.ENABLE
+		user.ModFiles = append(user.ModFiles, makeModFile(s3Client, user.ID, fileManager.FileName, fileManager.Prefix, size, installed, metadata))
+		for _, dependency := range dependencies {
+			var dependencySize int64
+			if f, err := os.Stat(dependency.Archive.ZipFilePath); err == nil {
+				dependencySize = f.Size()
+			}
+			user.ModFiles = append(user.ModFiles, makeModFile(s3Client, user.ID, filepath.Base(dependency.Archive.ZipFilePath), dependency.Prefix, dependencySize, true, dependency.Metadata))
+		}
 
 		for _, file := range user.ModFiles {
+			// Uninstalls don't touch the metadata so the mod still shows up properly in the UI.
+			updateColumns := []string{"installed", "size"}
+			if file.Creator != "" || file.Description != "" || file.HeroImage != "" {
+				updateColumns = append(updateColumns, "creator", "description", "hero_image")
+			}
+
 			db.Clauses(clause.OnConflict{
 				Columns:   []clause.Column{{Name: "file_name"}},
-				DoUpdates: clause.AssignmentColumns([]string{"installed", "size"}),
+				DoUpdates: clause.AssignmentColumns(updateColumns),
 			}).Create(&file)
 		}
 	}
 
 	if strings.HasSuffix(fileManager.FileDestinationPath, ".fwl") || strings.HasSuffix(fileManager.FileDestinationPath, ".db") {
-		// Allow only files which are not *_backup_auto-* since those files are replica backups there's no badge for install status
+		// Allow only files which are not *_backup_<label>-* since those files are replica backups there's no badge for install status
 		// on the UI for them and therefore they don't need to be stored in cognito wasting space.
 		backups, err := fileManager.ListFiles(cmd.BACKUPS_DIR, func(fileName string) bool {
 			return filepath.Ext(fileName) == ".db" || filepath.Ext(fileName) == ".fwl"
 		})
 
 		if err != nil {
-			log.Fatalf("failed to list backup files: %v", err)
+			fail(rabbit, fileManager, "list-backups", fmt.Errorf("failed to list backup files: %w", err))
 		}
 
 		for _, file := range backups {
-			if !strings.Contains(file.Name(), "_backup_auto-") {
+			if !cmd.IsWorldBackup(file.Name()) {
 				user.WorldFiles = append(user.WorldFiles, model.WorldFile{
 					BaseFile: model.BaseFile{
 						UserID:    user.ID,
-						Size:      size,
+						Size:      file.Size(),
 						FileName:  filepath.Base(file.Name()),
 						S3Key:     fmt.Sprintf("valheim-backups-auto/%s/%s", user.DiscordID, filepath.Base(file.Name())),
-						Installed: fileManager.Op == cmd.WRITE || fileManager.Op == cmd.COPY,
+						Installed: fileManager.Op == cmd.WRITE || fileManager.Op == cmd.COPY || fileManager.Op == cmd.RESTORE,
 					},
 				})
 			} else {
 				user.BackupFiles = append(user.BackupFiles, model.BackupFile{
 					BaseFile: model.BaseFile{
 						UserID:    user.ID,
-						Size:      size,
+						Size:      file.Size(),
 						FileName:  filepath.Base(file.Name()),
 						S3Key:     fmt.Sprintf("valheim-backups-auto/%s/%s", user.DiscordID, filepath.Base(file.Name())),
 						Installed: fileManager.Op == cmd.WRITE || fileManager.Op == cmd.COPY,
@@ -167,14 +294,14 @@ func main() {
 		for _, backup := range user.BackupFiles {
 			db.Clauses(clause.OnConflict{
 				Columns:   []clause.Column{{Name: "file_name"}},
-				DoUpdates: clause.AssignmentColumns([]string{"s3_key", "installed"}),
+				DoUpdates: clause.AssignmentColumns([]string{"s3_key", "installed", "size"}),
 			}).Create(&backup)
 		}
 
 		for _, world := range user.WorldFiles {
 			db.Clauses(clause.OnConflict{
 				Columns:   []clause.Column{{Name: "file_name"}},
-				DoUpdates: clause.AssignmentColumns([]string{"s3_key", "installed"}),
+				DoUpdates: clause.AssignmentColumns([]string{"s3_key", "installed", "size"}),
 			}).Create(&world)
 		}
 	}
@@ -206,10 +333,175 @@ func main() {
 	//if err != nil {
 	//	log.Fatalf("failed to scale deployment back to 1: %v", err)
 	//}
-	db.Save(&user)
+	if err := db.Save(&user).Error; err != nil {
+		fail(rabbit, fileManager, "save", fmt.Errorf("failed to save user files: %w", err))
+	}
+
+	// Success is only published once everything has been recorded so subscribers never see it followed by a failure.
+	err = rabbit.PublishEvent(fileManager.DiscordId, succeeded)
+	if err != nil {
+		log.Errorf("failed to publish %s event: %v", cmd.InstallSucceededType, err)
+	}
+	rabbit.Close()
 	log.Infof("done.")
 }
 
+// fail Publishes an InstallFailed event for the given stage of the Job so the user isn't left waiting on an operation
+// which will never finish, then exits.
+func fail(rabbit *cmd.RabbitMQService, fileManager *cmd.FileManager, stage string, err error) {
+	publishErr := rabbit.PublishEvent(fileManager.DiscordId, &cmd.InstallFailed{
+		EventMetadata: cmd.MakeEventMetadata(fileManager),
+		Stage:         stage,
+		Error:         err.Error(),
+	})
+	if publishErr != nil {
+		log.Errorf("failed to publish %s event: %v", cmd.InstallFailedType, publishErr)
+	}
+
+	rabbit.Close()
+	log.Fatal(err)
+}
+
+// backup Uploads the selected files from the PVC to the user's backups in S3. The server has already been stopped so
+// the world files aren't being written to while they're uploaded.
+func backup(rabbit *cmd.RabbitMQService, fileManager *cmd.FileManager, s3Client *cmd.S3Client) {
+	results, err := s3Client.Backup(fileManager)
+	if err != nil {
+		fail(rabbit, fileManager, "backup", fmt.Errorf("failed to back up %s after uploading %d files: %w", fileManager.Backup, len(results), err))
+	}
+
+	var bytes int64
+	for _, result := range results {
+		bytes += result.Bytes
+	}
+
+	publishProgress(rabbit, fileManager, makeProgress(fileManager, "backup", fmt.Sprintf("uploaded %d %s files", len(results), fileManager.Backup)))
+	publishProgress(rabbit, fileManager, &cmd.InstallSucceeded{EventMetadata: cmd.MakeEventMetadata(fileManager), Bytes: bytes})
+
+	rabbit.Close()
+	log.Infof("done.")
+}
+
+// prune Removes the world backups the retention policy doesn't keep from the PVC, optionally S3, and the user's backup
+// records. Records are removed for every file actually pruned even when some files couldn't be.
+func prune(rabbit *cmd.RabbitMQService, fileManager *cmd.FileManager, cfg aws.Config, s3Client *cmd.S3Client) {
+	policy := cmd.MakeRetentionPolicy()
+	report, pruneErr := fileManager.PruneBackups(policy, time.Now())
+	if report == nil {
+		fail(rabbit, fileManager, "prune", fmt.Errorf("failed to prune backups: %w", pruneErr))
+	}
+
+	if policy.DeleteFromS3 && len(report.Pruned) > 0 {
+		if err := s3Client.DeleteBackups(fileManager.DiscordId, report.Pruned); err != nil {
+			pruneErr = errors.Join(pruneErr, err)
+		}
+	}
+
+	db := model.Connect()
+	cognito := service.MakeCognitoService(cfg)
+	_, err := cognito.AuthUser(context.Background(), &fileManager.RefreshToken, &fileManager.DiscordId, db)
+	if err != nil {
+		fail(rabbit, fileManager, "authenticate", fmt.Errorf("failed to authenticate user: %w", err))
+	}
+
+	var user model.User
+	db.Where("discord_id = ?", fileManager.DiscordId).First(&user)
+
+	// Records are deleted outright rather than soft deleted since file names are unique.
+	if len(report.Pruned) > 0 {
+		tx := db.Unscoped().Where("user_id = ? AND file_name IN ?", user.ID, report.Pruned).Delete(&model.BackupFile{})
+		if tx.Error != nil {
+			pruneErr = errors.Join(pruneErr, fmt.Errorf("failed to delete backup records: %w", tx.Error))
+		}
+	}
+
+	if pruneErr != nil {
+		fail(rabbit, fileManager, "prune", fmt.Errorf("failed to prune backups after removing %d files: %w", len(report.Pruned), pruneErr))
+	}
+
+	message := fmt.Sprintf("removed %d backup files freeing %d bytes, kept %d", len(report.Pruned), report.Freed, len(report.Kept))
+	publishProgress(rabbit, fileManager, makeProgress(fileManager, "prune", message))
+	publishProgress(rabbit, fileManager, &cmd.InstallSucceeded{EventMetadata: cmd.MakeEventMetadata(fileManager)})
+
+	rabbit.Close()
+	log.Infof("done.")
+}
+
+// pruneCache Removes stale and least recently used objects from the download cache.
+func pruneCache(rabbit *cmd.RabbitMQService, fileManager *cmd.FileManager, s3Client *cmd.S3Client) {
+	report, err := s3Client.PruneCache()
+	if err != nil {
+		fail(rabbit, fileManager, "prune-cache", fmt.Errorf("failed to prune download cache: %w", err))
+	}
+
+	message := fmt.Sprintf("removed %d stale and %d least recently used objects freeing %d bytes, %d bytes cached", report.Stale, report.Evicted, report.Freed, report.Size)
+	log.Info(message)
+	publishProgress(rabbit, fileManager, makeProgress(fileManager, "prune-cache", message))
+	publishProgress(rabbit, fileManager, &cmd.InstallSucceeded{EventMetadata: cmd.MakeEventMetadata(fileManager)})
+
+	rabbit.Close()
+	log.Infof("done.")
+}
+
+func makeProgress(fileManager *cmd.FileManager, stage, message string) *cmd.InstallProgress {
+	return &cmd.InstallProgress{
+		EventMetadata: cmd.MakeEventMetadata(fileManager),
+		Stage:         stage,
+		Message:       message,
+	}
+}
+
+// publishProgress Publishes an informational event. Failing to publish one isn't worth failing the Job over.
+func publishProgress(rabbit *cmd.RabbitMQService, fileManager *cmd.FileManager, event cmd.Event) {
+	if err := rabbit.PublishEvent(fileManager.DiscordId, event); err != nil {
+		log.Errorf("failed to publish %s event: %v", event.EventType(), err)
+	}
+}
+
+// discardSnapshot Discards the snapshot of a world which was never replaced so a failed Job doesn't leave a prechange
+// backup behind which is just a copy of the current world. Failing to discard it is only logged.
+func discardSnapshot(s3Client *cmd.S3Client, snapshot *cmd.WorldSnapshot) {
+	if snapshot == nil {
+		return
+	}
+	if err := s3Client.DiscardSnapshot(snapshot); err != nil {
+		log.Errorf("failed to discard snapshot of world %s: %v", snapshot.World, err)
+	}
+}
+
+// makeModFile Creates the record for a mod filling in its creator, description and icon from the package metadata when
+// the mod has any. ModFile has no column for the package version so it's only published with InstallSucceeded.
+func makeModFile(s3Client *cmd.S3Client, userId uint, fileName, prefix string, size int64, installed bool, metadata *cmd.PackageMetadata) model.ModFile {
+	modFile := model.ModFile{
+		BaseFile: model.BaseFile{
+			UserID:    userId,
+			Size:      size,
+			FileName:  fileName,
+			Installed: installed,
+			S3Key:     prefix,
+		},
+		UpVotes:            0,
+		Downloads:          0,
+		OriginalUploadDate: time.Now(),
+		LatestUploadDate:   time.Now(),
+	}
+
+	if metadata == nil {
+		return modFile
+	}
+
+	modFile.Creator = metadata.Creator()
+	modFile.Description = metadata.Description
+	if metadata.Icon != nil {
+		key, err := s3Client.UploadIcon(prefix, metadata.Icon)
+		if err != nil {
+			log.Errorf("failed to upload icon for %s: %v", fileName, err)
+		}
+		modFile.HeroImage = key
+	}
+	return modFile
+}
+
 func isConfigFile(path string) bool {
 	return strings.HasSuffix(path, ".cfg") || strings.HasSuffix(path, ".json") || strings.HasSuffix(path, ".yaml")
 }